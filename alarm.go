@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// The FAULT column is a bitmask and Overht holds the overheat flags. Every
+// bit that gets set raises an alarm, named by the models.FaultCode of the
+// bit, that stays active until the bit is cleared. Alarms are stored in the
+// database, the ones raised while recording belong to the log file.
+
+// alarmColumns are the columns that hold fault bits
+var alarmColumns = []string{"FAULT", "Overht"}
+
+type alarmsReport struct {
+	Cmd    string
+	Port   string
+	Alarms []models.Alarm
+}
+
+// alarmTracker raises and clears the alarms of a port, it is guarded by
+// inOutLock
+type alarmTracker struct {
+	// ids of the active alarms keyed by code
+	active map[string]int
+}
+
+// trackAlarms raises and clears the alarms of the bits of a fault column
+func (b *Bufferflow3Devo) trackAlarms(column string, value string, deviceTime string) {
+	// a salvaged line can miss the value
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	if b.alarms.active == nil {
+		b.alarms.active = make(map[string]int)
+	}
+	bits := uint32(number)
+	for bit := 0; bit < 32; bit++ {
+		code := models.FaultCodeOf(column, bit)
+		id, active := b.alarms.active[code]
+		isSet := bits&(1<<uint(bit)) != 0
+		if isSet && !active {
+			b.raiseAlarm(column, bit, deviceTime)
+		} else if !isSet && active {
+			b.clearAlarm(code, id)
+		}
+	}
+}
+
+func (b *Bufferflow3Devo) raiseAlarm(column string, bit int, deviceTime string) {
+	code := models.FaultCodeOf(column, bit)
+	faultCode := models.FaultCode{}
+	if err := db.One("Code", code, &faultCode); err != nil {
+		// bits that are not in the catalogue are named after the column
+		faultCode = models.FaultCode{Code: code, Name: column + " bit " + strconv.Itoa(bit), Severity: "critical"}
+	}
+	alarm := models.Alarm{
+		Port:        b.Port,
+		Code:        code,
+		Name:        faultCode.Name,
+		Severity:    faultCode.Severity,
+		Description: faultCode.Description,
+		Time:        deviceTime,
+		RaisedAt:    time.Now().Unix(),
+	}
+	if logFile := b.GetLogFile(); logFile != nil {
+		alarm.LogFileUUID = logFile.UUID
+	}
+	if err := db.Save(&alarm); err != nil {
+		log.Println("Can't save alarm " + code + " of " + b.Port + ": " + err.Error())
+		return
+	}
+	b.alarms.active[code] = alarm.ID
+	log.Printf("Alarm %v (%v) raised on %v\n", alarm.Name, alarm.Severity, b.Port)
+	sendAlarmReport("AlarmRaised", alarm)
+}
+
+func (b *Bufferflow3Devo) clearAlarm(code string, id int) {
+	delete(b.alarms.active, code)
+	// only ClearedAt is written so the alarm can be acknowledged meanwhile
+	if err := db.UpdateField(&models.Alarm{ID: id}, "ClearedAt", time.Now().Unix()); err != nil {
+		log.Println("Can't save alarm " + code + " of " + b.Port + ": " + err.Error())
+		return
+	}
+	alarm := models.Alarm{}
+	db.One("ID", id, &alarm)
+	log.Printf("Alarm %v cleared on %v\n", alarm.Name, b.Port)
+	sendAlarmReport("AlarmCleared", alarm)
+}
+
+// clearAlarms clears the active alarms when the port closes, nothing tells
+// whether they are still going on
+func (b *Bufferflow3Devo) clearAlarms() {
+	for code, id := range b.alarms.active {
+		b.clearAlarm(code, id)
+	}
+}
+
+// clearStaleAlarms clears the alarms left active when we stopped
+func clearStaleAlarms() {
+	alarms := []models.Alarm{}
+	db.Select(q.Eq("ClearedAt", int64(0))).Find(&alarms)
+	for _, alarm := range alarms {
+		db.UpdateField(&models.Alarm{ID: alarm.ID}, "ClearedAt", time.Now().Unix())
+	}
+}
+
+// spAlarm handles the alarm commands
+//
+//	alarms <port>
+//	acknowledge <alarm id>
+func spAlarm(c *connection, args []string) {
+	if len(args) < 2 {
+		spErr(c, "Usage: alarms <port> or acknowledge <alarm id>")
+		return
+	}
+	if strings.ToLower(args[0]) == "alarms" {
+		bytes, err := json.Marshal(alarmsReport{Cmd: "Alarms", Port: args[1], Alarms: activeAlarms(args[1])})
+		if err == nil {
+			sendReply(c, bytes)
+		}
+		return
+	}
+
+	id, _ := strconv.Atoi(args[1])
+	if _, err := acknowledgeAlarm(id); err != nil {
+		spErr(c, err.Error())
+	}
+}
+
+// activeAlarms returns the alarms of the port that were not cleared yet, the
+// most severe first
+func activeAlarms(portname string) []models.Alarm {
+	alarms := []models.Alarm{}
+	db.Select(q.Eq("ClearedAt", int64(0)), q.Eq("Port", portname)).Find(&alarms)
+	models.SortAlarms(alarms)
+	return alarms
+}
+
+// acknowledgeAlarm marks the alarm as seen by an operator, acknowledging it
+// again does nothing
+func acknowledgeAlarm(id int) (models.Alarm, error) {
+	alarm, err := models.AcknowledgeAlarm(env, id)
+	if err == storm.ErrNotFound {
+		return alarm, errors.New("We could not find the alarm " + strconv.Itoa(id))
+	} else if err != nil {
+		return alarm, errors.New("Could not acknowledge the alarm: " + err.Error())
+	}
+	return alarm, nil
+}
+
+func sendAlarmReport(cmd string, alarm models.Alarm) {
+	bytes, err := json.Marshal(models.AlarmEvent{Cmd: cmd, Port: alarm.Port, Alarm: alarm})
+	if err == nil {
+		sendPortMessage(alarm.Port, bytes)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+// portAllowlist decides which serial ports are listed, it is kept in the
+// config so it can be changed through the config REST API
+type portAllowlist struct {
+	devices  []models.AllowedDevice
+	patterns []*regexp.Regexp
+	showAll  bool
+}
+
+// defaultAllowedDevices are the devices listed when nothing is configured
+func defaultAllowedDevices() []models.AllowedDevice {
+	return []models.AllowedDevice{{Vid: DevoUsbVID, Pid: DevoUsbPID, Name: "3devo Filament Maker"}}
+}
+
+// isDevoDevice tells whether the port is a 3devo Filament Maker by its usb
+// VID/PID
+func isDevoDevice(port SpPortItem) bool {
+	return strings.EqualFold(port.UsbVid, DevoUsbVID) && strings.EqualFold(port.UsbPid, DevoUsbPID)
+}
+
+// loadPortAllowlist reads the allowlist from the config
+func loadPortAllowlist() portAllowlist {
+	config := models.Config{AllowedDevices: defaultAllowedDevices()}
+	if db != nil {
+		db.One("ID", 1, &config)
+	}
+	allowlist := portAllowlist{devices: config.AllowedDevices, showAll: config.ShowAllPorts}
+	for _, pattern := range config.PortPatterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			log.Printf("Ignoring invalid port pattern %v: %v\n", pattern, err)
+			continue
+		}
+		allowlist.patterns = append(allowlist.patterns, re)
+	}
+	return allowlist
+}
+
+// allows tells whether the port is listed. A port is listed when its usb
+// VID/PID is allowed or its name matches one of the patterns, like the
+// -regex flag does.
+func (allowlist portAllowlist) allows(port SpPortItem) bool {
+	if allowlist.showAll {
+		return true
+	}
+	for _, device := range allowlist.devices {
+		if strings.EqualFold(port.UsbVid, device.Vid) && strings.EqualFold(port.UsbPid, device.Pid) {
+			return true
+		}
+	}
+	for _, re := range allowlist.patterns {
+		if re.MatchString(port.Name) || re.MatchString(port.Friendly) {
+			return true
+		}
+	}
+	return false
+}
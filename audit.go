@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every port keeps a trail of the last commands sent to it and what became
+// of them, so a client can look back on why a command did not go through.
+
+// the number of events kept per port
+const auditMaxEvents = 1000
+
+type auditEvent struct {
+	Time   time.Time
+	Event  string
+	Id     string
+	D      string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
+type auditReport struct {
+	Cmd    string
+	Port   string
+	Events []auditEvent
+}
+
+// Command trails keyed by the lowercase port name
+var spAudit = struct {
+	lock   *sync.Mutex
+	trails map[string][]auditEvent
+}{
+	lock:   &sync.Mutex{},
+	trails: make(map[string][]auditEvent),
+}
+
+// auditCmd adds an event to the command trail of a port
+func auditCmd(portname string, event string, id string, data string, reason string) {
+	spAudit.lock.Lock()
+	defer spAudit.lock.Unlock()
+	key := strings.ToLower(portname)
+	trail := append(spAudit.trails[key], auditEvent{
+		Time:   time.Now(),
+		Event:  event,
+		Id:     id,
+		D:      data,
+		Reason: reason,
+	})
+	if len(trail) > auditMaxEvents {
+		trail = trail[len(trail)-auditMaxEvents:]
+	}
+	spAudit.trails[key] = trail
+}
+
+// spAuditList handles the audit command
+//
+//	audit <port>
+func spAuditList(c *connection, args []string) {
+	if len(args) < 2 {
+		spErr(c, "You did not specify a port to show the command trail of")
+		return
+	}
+	spAudit.lock.Lock()
+	events := append([]auditEvent{}, spAudit.trails[strings.ToLower(args[1])]...)
+	spAudit.lock.Unlock()
+
+	report, err := json.Marshal(auditReport{Cmd: "Audit", Port: args[1], Events: events})
+	if err == nil {
+		sendReply(c, report)
+	}
+}
@@ -47,4 +47,6 @@ type DataCmdComplete struct {
 type DataPerLine struct {
 	P string
 	D string
+	// implausible values of the line
+	Anomalies []anomaly `json:",omitempty"`
 }
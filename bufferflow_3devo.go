@@ -32,12 +32,47 @@ type Bufferflow3Devo struct {
 	lock         *sync.Mutex
 	manualLock   *sync.Mutex
 	BufferMax    int
+	// The log file incoming lines are recorded to, nil when not recording
+	LogFile    *models.LogFile
+	recordLock *sync.Mutex
+	// Called when the device identifies itself
+	OnIdentity func(identity deviceIdentity)
+	// Hands an unacknowledged command to the writer again
+	Resend func(cmd Cmd)
+	// Commands waiting for an acknowledgement within their timeout, keyed by
+	// id and guarded by inOutLock
+	timeouts map[string]*cmdTimeout
+	// Commands written to the device that were not acknowledged yet, in the
+	// order they were written, guarded by inOutLock
+	written []writtenCmd
+	// the columns of the header and the last valid data line with the time
+	// it came in
+	header     []string
+	columns    []schemaColumn
+	latest     []string
+	latestAt   time.Time
+	valuesLock *sync.Mutex
+	// No new data for this long counts as a stalled stream, 0 turns the
+	// watchdog off
+	StallTimeout time.Duration
+	watchdog     streamWatchdog
+	// the typed columns of the header, used by the input loop only
+	schema schemaReport
+	// Keep the well-formed fields of corrupt lines
+	Salvage bool
+	// the Status of the machine, used by the input loop only
+	status     statusMachine
+	alarms     alarmTracker
+	corruption corruptionTracker
+	// id of the command waiting in BlockUntilReady, guarded by inOutLock
+	blockedId string
+	isBlocked bool
 }
 
 // generateRegexFromHeaders takes the incoming headers and matches them up with the one found in the configuration struct
 // It then creates a regex based on the format of the headers using the validator value from the configuration
 // If a header is unknown to the configuration it will throw an error
-func generateRegexFromHeaders(headers []string, knownColumns *map[string]Column) string {
+func generateRegexFromHeaders(headers []string, knownColumns *map[string]models.Column) string {
 	genericMatch := `[^\t]*`
 	generatedRegex := "^"
 
@@ -46,7 +81,8 @@ func generateRegexFromHeaders(headers []string, knownColumns *map[string]Column)
 			log.Println("HEADER => unknown header '" + headerName + "', defaulting to generic match regex")
 			generatedRegex += genericMatch
 		} else {
-			generatedRegex += column.Validator
+			// validators can hold alternatives
+			generatedRegex += `(?:` + column.ValueValidator() + `)`
 		}
 		if index < len(headers)-1 {
 			generatedRegex += `\t`
@@ -73,19 +109,22 @@ func (b *Bufferflow3Devo) Init() {
 	b.ManualPaused = false
 	b.lock = &sync.Mutex{}
 	b.manualLock = &sync.Mutex{}
+	b.recordLock = &sync.Mutex{}
+	b.timeouts = make(map[string]*cmdTimeout)
+	b.valuesLock = &sync.Mutex{}
+	b.startWatchdog()
 	b.Input = make(chan string)
 	b.BufferMax = 2
 
 	var validateLogRegex *regexp.Regexp
+	var columnValidators []*regexp.Regexp
+	var checks *plausibility
+	statusIndex := -1
+	alarmIndexes := make(map[string]int)
+	corruptBurst := 0
 	initCompleted := false
 	lastTime := "0"
 
-	query := db.Select().Limit(1).OrderBy("Timestamp").Reverse()
-	var logFile = models.LogFile{}
-	err := query.First(&logFile)
-	if err != nil {
-		log.Println(Red("Can not find logfile"))
-	}
 	go func() {
 		for data := range b.Input {
 
@@ -124,35 +163,123 @@ func (b *Bufferflow3Devo) Init() {
 				// Check if incoming data contains corruption
 				splitLine := strings.Split(element, "\t")
 
+				identity, isIdentity := parseIdentity(element)
+				if isIdentity && b.OnIdentity != nil {
+					b.OnIdentity(identity)
+				}
+
+				// the device acknowledges every command it processed
+				isCmdDone := b.reCmdDone.MatchString(element)
+				if isCmdDone {
+					b.completeCmd(element)
+				}
+
+				// the device prints its header after every boot
+				isHeader := splitLine[0] == "Time"
+
 				// For now only check on data that starts with a digit
-				if initCompleted {
+				isData := false
+				if initCompleted && !isIdentity && !isCmdDone && !isHeader {
 					// Bruteforced regex to check if the line matches with our current (01-29-2019) log format
 					if match := validateLogRegex.MatchString(element); match == false {
-						log.Println(Red("Corrupt data found -> "), Blue(element))
-						h.broadcastSys <- []byte("{\"Cmd\":\"Error\",\"Desc\":\"Corrupt data occurred around time:" + lastTime + "\",\"Port\":\"" + b.Port + "\"}")
-						// Drop entire line
-						continue
+						corruptBurst++
+						salvaged, isSalvaged := []string(nil), false
+						if b.Salvage {
+							salvaged, isSalvaged = salvageLine(splitLine, columnValidators)
+						}
+						b.recordCorrupt(element, isSalvaged, corruptBurst)
+						if !isSalvaged {
+							log.Println(Red("Corrupt data found -> "), Blue(element))
+							sendPortMessage(b.Port, []byte("{\"Cmd\":\"Error\",\"Desc\":\"Corrupt data occurred around time:"+lastTime+"\",\"Port\":\""+b.Port+"\"}"))
+							// Drop entire line
+							continue
+						}
+						log.Println(Red("Corrupt data salvaged -> "), Blue(element))
+						sendPortMessage(b.Port, []byte("{\"Cmd\":\"Error\",\"Desc\":\"Corrupt data salvaged around time:"+lastTime+"\",\"Port\":\""+b.Port+"\"}"))
+						splitLine = salvaged
+						element = strings.Join(salvaged, "\t")
 					} else {
+						corruptBurst = 0
+						b.saveCorruptionIfDue()
+					}
+					if splitLine[0] != "" {
 						lastTime = splitLine[0]
 					}
+					b.setLatest(splitLine)
+					isData = true
 				}
 
-				if !initCompleted && splitLine[0] == "Time" {
-					generatedRegex := generateRegexFromHeaders(splitLine, &KnownColumns)
+				if isHeader {
+					columns := knownColumns()
+					generatedRegex := generateRegexFromHeaders(splitLine, &columns)
 					validateLogRegex = regexp.MustCompile(generatedRegex)
+					columnValidators = generateColumnValidators(splitLine, &columns)
+					checks = newPlausibility(splitLine, columns)
+					statusIndex = -1
+					alarmIndexes = make(map[string]int)
+					for i, name := range splitLine {
+						if name == "Status" {
+							statusIndex = i
+						}
+						for _, column := range alarmColumns {
+							if name == column {
+								alarmIndexes[column] = i
+							}
+						}
+					}
+					previous := b.schema
+					b.schema = newSchema(b.Port, splitLine, columns)
+					b.setHeader(splitLine, b.schema.Columns)
+					setSchema(b.schema)
+					if initCompleted {
+						// the lines after this header belong to a new segment
+						// of the log file
+						if logFile := b.GetLogFile(); logFile != nil {
+							logFile.AppendLog("\n", env)
+						}
+						if added, removed, changed := diffSchema(previous, b.schema); changed {
+							log.Printf("Columns of %v changed, added %v removed %v\n", b.Port, added, removed)
+							sendSchemaChanged(b.schema, added, removed)
+						}
+					}
 					initCompleted = true
 				}
 
-				m := DataPerLine{b.Port, element + "\n"}
+				// implausible values are flagged, the line is kept
+				var anomalies []anomaly
+				if isData {
+					anomalies = checks.check(splitLine)
+					checks.record(b.GetLogFile(), anomalies, lastTime)
+					if statusIndex >= 0 && statusIndex < len(splitLine) {
+						b.trackStatus(splitLine[statusIndex], lastTime)
+					}
+					for column, i := range alarmIndexes {
+						if i < len(splitLine) {
+							b.trackAlarms(column, splitLine[i], lastTime)
+						}
+					}
+				}
+
+				m := DataPerLine{b.Port, element + "\n", anomalies}
 
 				bm, err := json.Marshal(m)
 				if err == nil {
-					err := logFile.AppendLog(m.D, env)
-					if err != nil {
-						log.Println(Red(fmt.Sprintf("Can't write to log file -> %v", logFile.GetFileName())))
+					// lines are only persisted while recording, acknowledgements
+					// are no log data
+					if logFile := b.GetLogFile(); logFile != nil && !isCmdDone {
+						err := logFile.AppendLog(m.D, env)
+						if err != nil {
+							log.Println(Red(fmt.Sprintf("Can't write to log file -> %v", logFile.GetFileName())))
+						}
 					}
 					//log.Println(Green("Sending data -> "), m.D)
-					h.broadcastSys <- bm
+					if !isData {
+						sendPortMessage(b.Port, bm)
+					} else if tm, err := json.Marshal(b.schema.telemetry(splitLine, anomalies)); err == nil {
+						h.broadcastTelemetry <- telemetryBroadcast{port: b.Port, raw: bm, typed: tm}
+					} else {
+						sendPortMessage(b.Port, bm)
+					}
 				}
 
 			} // for loop
@@ -181,6 +308,118 @@ func (b *Bufferflow3Devo) Init() {
 
 }
 
+// maxWritten is how many unacknowledged commands are remembered, the oldest
+// are forgotten as a device does not acknowledge every line it gets
+const maxWritten = 100
+
+// writtenCmd is a command written to the device, every command the device
+// processes is acknowledged with a line that echoes it
+type writtenCmd struct {
+	id   string
+	data string
+	// only commands that went through the buffer complete, the device
+	// acknowledges NoBuf writes too
+	buffered bool
+	// the command got wiped or failed, or another write of it was
+	// acknowledged already. Its acknowledgement completes nothing.
+	dropped bool
+}
+
+// Writing remembers a command that is about to be written to the device, so
+// its acknowledgement can be told apart from the others.
+// go-routine safe.
+func (b *Bufferflow3Devo) Writing(cmd Cmd) {
+	if strings.TrimSpace(cmd.data) == "" {
+		// the device does not acknowledge empty lines
+		return
+	}
+	b.inOutLock.Lock()
+	defer b.inOutLock.Unlock()
+	_, waiting := b.timeouts[cmd.id]
+	b.written = append(b.written, writtenCmd{
+		id:       cmd.id,
+		data:     strings.TrimSpace(cmd.data),
+		buffered: !cmd.skippedBuffer,
+		// acknowledged or failed while the resend waited for the writer
+		dropped: cmd.resend && !waiting,
+	})
+	if len(b.written) > maxWritten {
+		b.written = b.written[len(b.written)-maxWritten:]
+	}
+}
+
+// dropWritten makes the acknowledgements of the written commands with an id
+// complete nothing. It must be called with inOutLock held.
+func (b *Bufferflow3Devo) dropWritten(id string) {
+	for i := range b.written {
+		if b.written[i].id == id {
+			b.written[i].dropped = true
+		}
+	}
+}
+
+// ackedCmd returns the index of the written command an acknowledgement
+// belongs to, the first one it echoes, or the oldest one when the device did
+// not echo a command. It returns -1 when no written command matches. It
+// must be called with inOutLock held.
+func (b *Bufferflow3Devo) ackedCmd(ack string) int {
+	if len(b.written) == 0 {
+		return -1
+	}
+	echo := strings.TrimSpace(b.reCmdDone.ReplaceAllString(ack, ""))
+	if echo == "" {
+		return 0
+	}
+	for i, cmd := range b.written {
+		// errors echo the command after the reason
+		if echo == cmd.data || strings.HasSuffix(echo, " "+cmd.data) {
+			return i
+		}
+	}
+	return -1
+}
+
+// completeCmd takes the command an acknowledgement belongs to off the
+// written commands. When it went through the buffer the client is told it
+// completed and the writer can send the next one. It must be called with
+// inOutLock held.
+func (b *Bufferflow3Devo) completeCmd(ack string) {
+	i := b.ackedCmd(ack)
+	if i < 0 {
+		log.Println("Got an acknowledgement that no written command was waiting for: " + ack)
+		return
+	}
+	cmd := b.written[i]
+	b.written = append(b.written[:i], b.written[i+1:]...)
+	if !cmd.buffered || cmd.dropped {
+		return
+	}
+	id := cmd.id
+	b.q.Remove(id)
+	b.stopTimeout(id)
+	if id != "" {
+		// the resends of the command are acknowledged too
+		b.dropWritten(id)
+	}
+	auditCmd(b.Port, "Complete", id, "", "")
+
+	qwr := qwReport{
+		Cmd:  "Complete",
+		QCnt: b.q.Len(),
+		Id:   id,
+		P:    b.Port,
+	}
+	qwrJson, err := json.Marshal(qwr)
+	if err == nil {
+		sendPortMessage(b.Port, qwrJson)
+	}
+
+	if b.GetPaused() && !b.GetManualPaused() && b.q.Len() < b.BufferMax {
+		log.Printf("\tQueue Len: %v is below BufferMax: %v, unpausing\n", b.q.Len(), b.BufferMax)
+		b.SetPaused(false, 1)
+	}
+}
+
 func ByteArrayEquals(a []byte, b []byte) bool {
 	if len(a) != len(b) {
 		return false
@@ -210,6 +449,9 @@ func (b *Bufferflow3Devo) BlockUntilReady(cmd string, id string) (bool, bool, st
 	if b.q.Len() >= b.BufferMax {
 		b.SetPaused(true, 0) // b.Paused = true
 		log.Printf("\tIt looks like the local queue at Len: %v is over the allowed size of BufferMax: %v, so we are going to pause. Then when some incoming responses come in a check will occur to see if there's room to send this command. Pausing...", b.q.Len(), b.BufferMax)
+	} else if b.GetManualPaused() {
+		b.SetPaused(true, 0)
+		log.Printf("\tWe are manually paused, so we wait until resumed. Pausing...")
 	}
 
 	if b.GetPaused() {
@@ -219,6 +461,10 @@ func (b *Bufferflow3Devo) BlockUntilReady(cmd string, id string) (bool, bool, st
 		// clear all b.sem signals so when we block below, we truly block
 		b.ClearOutSemaphore()
 
+		// remember who is blocked so it can be cancelled
+		b.blockedId = id
+		b.isBlocked = true
+
 		// since we need other code to run while we're blocking, we better release the packet ctr lock
 		b.inOutLock.Unlock()
 		// since we already unlocked this thread, note it so we don't doubly unlock
@@ -227,6 +473,10 @@ func (b *Bufferflow3Devo) BlockUntilReady(cmd string, id string) (bool, bool, st
 		log.Println("\tBlocking on b.sem until told from OnIncomingData to go")
 		unblockType, ok := <-b.sem // will block until told from OnIncomingData to go
 
+		b.inOutLock.Lock()
+		b.isBlocked = false
+		b.inOutLock.Unlock()
+
 		log.Printf("\tDone blocking cuz got b.sem semaphore release. ok:%v, unblockType:%v\n", ok, unblockType)
 
 		log.Printf("\tDone blocking cuz got b.sem semaphore release. ok:%v, unblockType:%v\n", ok, unblockType)
@@ -280,28 +530,73 @@ func (b *Bufferflow3Devo) BreakApartCommands(cmd string) []string {
 	return []string{cmd}
 }
 
+// Pause stops sending commands, the command being written completes but
+// the next one waits in BlockUntilReady until Unpause
 func (b *Bufferflow3Devo) Pause() {
-	return
+	log.Println("Pausing the 3devo buffer")
+	b.SetManualPaused(true)
 }
 
+// Unpause lets the command waiting in BlockUntilReady go when there is room
+// in the queue, otherwise the next acknowledgement lets it go
 func (b *Bufferflow3Devo) Unpause() {
-	return
+	log.Println("Unpausing the 3devo buffer")
+	b.SetManualPaused(false)
+	if b.GetPaused() && b.q.Len() < b.BufferMax {
+		b.SetPaused(false, 1)
+	}
+}
+
+// Cancel drops the command waiting in BlockUntilReady when it has the id.
+// Commands that are written already can not be cancelled.
+func (b *Bufferflow3Devo) Cancel(id string) bool {
+	b.inOutLock.Lock()
+	defer b.inOutLock.Unlock()
+	if !b.isBlocked || b.blockedId != id {
+		return false
+	}
+	b.isBlocked = false
+	b.q.Remove(id)
+	// an unblock of type 2 makes BlockUntilReady drop the command
+	b.SetPaused(false, 2)
+	return true
+}
+
+// Waiting returns the commands that are written and waiting for an
+// acknowledgement, followed by the one waiting to be written
+func (b *Bufferflow3Devo) Waiting() ([]string, []string) {
+	return b.q.Items()
 }
 
 func (b *Bufferflow3Devo) SeeIfSpecificCommandsShouldSkipBuffer(cmd string) bool {
 	return false
 }
 
+// The Filament Maker has no commands that control the buffer, !, ~ and %
+// go to the device like any other command. Sending one of these lines
+// instead controls the buffer like the pause, resume and flush commands do,
+// they are not written to the device.
+const (
+	bufferPauseLine  = "#pause"
+	bufferResumeLine = "#resume"
+	bufferFlushLine  = "#flush"
+)
+
+// isBufferControl tells whether a command is the buffer control line
+func isBufferControl(cmd string, line string) bool {
+	return strings.EqualFold(strings.TrimSpace(cmd), line)
+}
+
 func (b *Bufferflow3Devo) SeeIfSpecificCommandsShouldPauseBuffer(cmd string) bool {
-	return false
+	return isBufferControl(cmd, bufferPauseLine)
 }
 
 func (b *Bufferflow3Devo) SeeIfSpecificCommandsShouldUnpauseBuffer(cmd string) bool {
-	return false
+	return isBufferControl(cmd, bufferResumeLine)
 }
 
 func (b *Bufferflow3Devo) SeeIfSpecificCommandsShouldWipeBuffer(cmd string) bool {
-	return false
+	return isBufferControl(cmd, bufferFlushLine)
 }
 
 func (b *Bufferflow3Devo) SeeIfSpecificCommandsReturnNoResponse(cmd string) bool {
@@ -320,6 +615,13 @@ func (b *Bufferflow3Devo) SeeIfSpecificCommandsReturnNoResponse(cmd string) bool
 func (b *Bufferflow3Devo) ReleaseLock() {
 	log.Println("Wiping NodeMCU buffer")
 
+	b.inOutLock.Lock()
+	b.stopTimeouts()
+	// the device still acknowledges the commands that were written
+	for i := range b.written {
+		b.written[i].dropped = true
+	}
+	b.inOutLock.Unlock()
 	b.q.Delete()
 	b.SetPaused(false, 2)
 }
@@ -333,6 +635,14 @@ func (b *Bufferflow3Devo) Close() {
 	}
 	b.IsOpen = false
 
+	b.inOutLock.Lock()
+	b.stopTimeouts()
+	b.clearAlarms()
+	b.flushCorruption()
+	b.inOutLock.Unlock()
+	b.stopWatchdog()
+	removeSchema(b.Port)
+
 	//b.ticker.Stop()
 	close(b.Input)
 }
@@ -377,3 +687,51 @@ func (b *Bufferflow3Devo) SetManualPaused(isPaused bool) {
 	defer b.manualLock.Unlock()
 	b.ManualPaused = isPaused
 }
+
+func (b *Bufferflow3Devo) setHeader(header []string, columns []schemaColumn) {
+	b.valuesLock.Lock()
+	defer b.valuesLock.Unlock()
+	b.header = header
+	b.columns = columns
+	b.latest = nil
+	b.latestAt = time.Time{}
+}
+
+func (b *Bufferflow3Devo) setLatest(line []string) {
+	b.valuesLock.Lock()
+	defer b.valuesLock.Unlock()
+	b.latest = line
+	b.latestAt = time.Now()
+	b.watchData(line)
+}
+
+//	Gets the values of the last valid data line keyed by column name, empty
+//	when no data line came in yet.
+//	go-routine safe.
+func (b *Bufferflow3Devo) Latest() map[string]string {
+	b.valuesLock.Lock()
+	defer b.valuesLock.Unlock()
+	values := make(map[string]string)
+	for i, value := range b.latest {
+		if i < len(b.header) {
+			values[b.header[i]] = value
+		}
+	}
+	return values
+}
+
+//	Gets the log file incoming lines are recorded to.
+//	go-routine safe.
+func (b *Bufferflow3Devo) GetLogFile() *models.LogFile {
+	b.recordLock.Lock()
+	defer b.recordLock.Unlock()
+	return b.LogFile
+}
+
+//	Sets the log file incoming lines are recorded to, nil stops recording.
+//	go-routine safe.
+func (b *Bufferflow3Devo) SetLogFile(logFile *models.LogFile) {
+	b.recordLock.Lock()
+	defer b.recordLock.Unlock()
+	b.LogFile = logFile
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBufferflow(t *testing.T) *Bufferflow3Devo {
+	b := &Bufferflow3Devo{Port: "test"}
+	b.Init()
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestAckedCmd(t *testing.T) {
+	written := []writtenCmd{
+		{id: "1", data: "SetT1 5", buffered: true},
+		{id: "", data: "info"},
+		{id: "2", data: "SetT2 7", buffered: true},
+	}
+	tests := []struct {
+		name    string
+		written []writtenCmd
+		ack     string
+		want    int
+	}{
+		{"echo of the oldest command", written, "> SetT1 5", 0},
+		{"echo of a later command", written, "> SetT2 7", 2},
+		{"error echoing the command", written, "> ERR unknown command: info", 1},
+		{"no echo takes the oldest command", written, ">", 0},
+		{"mismatched echo", written, "> SetT3 1", -1},
+		{"echo of a part of a command", written, "> 5", -1},
+		{"nothing written", nil, "> SetT1 5", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBufferflow(t)
+			b.written = append([]writtenCmd{}, test.written...)
+			if got := b.ackedCmd(test.ack); got != test.want {
+				t.Errorf("ackedCmd(%q) = %v, want %v", test.ack, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompleteCmd(t *testing.T) {
+	tests := []struct {
+		name string
+		ack  string
+		// ids left in the queue and data left in the written commands
+		wantQueued  []string
+		wantWritten []string
+	}{
+		{"completes the echoed command", "> SetT2 7", []string{"1"}, []string{"SetT1 5", "info"}},
+		{"leaves the buffer on an unbuffered echo", "> info", []string{"1", "2"}, []string{"SetT1 5", "SetT2 7"}},
+		{"ignores a mismatched echo", "> SetT3 1", []string{"1", "2"}, []string{"SetT1 5", "info", "SetT2 7"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBufferflow(t)
+			b.q.Push("SetT1 5\n", "1")
+			b.q.Push("SetT2 7\n", "2")
+			b.Writing(Cmd{data: "SetT1 5\n", id: "1"})
+			b.Writing(Cmd{data: "info\n", skippedBuffer: true})
+			b.Writing(Cmd{data: "SetT2 7\n", id: "2"})
+
+			b.inOutLock.Lock()
+			b.completeCmd(test.ack)
+			b.inOutLock.Unlock()
+
+			_, queued := b.q.Items()
+			if !equalStrings(queued, test.wantQueued) {
+				t.Errorf("queued %v, want %v", queued, test.wantQueued)
+			}
+			written := []string{}
+			for _, cmd := range b.written {
+				written = append(written, cmd.data)
+			}
+			if !equalStrings(written, test.wantWritten) {
+				t.Errorf("written %v, want %v", written, test.wantWritten)
+			}
+		})
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBufferControl(t *testing.T) {
+	tests := []struct {
+		cmd                  string
+		pause, unpause, wipe bool
+	}{
+		{"#pause", true, false, false},
+		{"#PAUSE\n", true, false, false},
+		{" #resume \n", false, true, false},
+		{"#flush", false, false, true},
+		{"!", false, false, false},
+		{"~", false, false, false},
+		{"%", false, false, false},
+		{"SetT1 5 #pause", false, false, false},
+	}
+	b := &Bufferflow3Devo{}
+	for _, test := range tests {
+		pause := b.SeeIfSpecificCommandsShouldPauseBuffer(test.cmd)
+		unpause := b.SeeIfSpecificCommandsShouldUnpauseBuffer(test.cmd)
+		wipe := b.SeeIfSpecificCommandsShouldWipeBuffer(test.cmd)
+		if pause != test.pause || unpause != test.unpause || wipe != test.wipe {
+			t.Errorf("%q pauses %v, unpauses %v, wipes %v, want %v, %v, %v", test.cmd, pause, unpause, wipe, test.pause, test.unpause, test.wipe)
+		}
+	}
+}
+
+func TestExpectAck(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      Cmd
+		waitsAck bool
+	}{
+		{"buffered command with a timeout", Cmd{data: "SetT1 5\n", id: "1", timeout: 1000}, true},
+		{"no timeout", Cmd{data: "SetT1 5\n", id: "1"}, false},
+		{"no id", Cmd{data: "SetT1 5\n", timeout: 1000}, false},
+		{"nobuf command", Cmd{data: "SetT1 5\n", id: "1", timeout: 1000, skippedBuffer: true}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBufferflow(t)
+			b.Writing(test.cmd)
+			b.ExpectAck(test.cmd)
+			b.inOutLock.Lock()
+			_, waiting := b.timeouts[test.cmd.id]
+			b.stopTimeouts()
+			b.inOutLock.Unlock()
+			if waiting != test.waitsAck {
+				t.Errorf("waits for an acknowledgement %v, want %v", waiting, test.waitsAck)
+			}
+		})
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		// the write that gets acknowledged, 0 when none is
+		ackedWrite  int
+		wantResends int
+	}{
+		{"fails without retries", 0, 0, 0},
+		{"fails after its retries", 2, 0, 2},
+		{"completes when acknowledged in time", 2, 1, 0},
+		{"completes when a retry is acknowledged", 2, 2, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBufferflow(t)
+			resent := make(chan Cmd, 10)
+			b.Resend = func(cmd Cmd) { resent <- cmd }
+			cmd := Cmd{data: "SetT1 5\n", id: "1", timeout: 20, retries: test.retries}
+			b.q.Push(cmd.data, cmd.id)
+
+			resends := 0
+			for write := 1; ; write++ {
+				b.Writing(cmd)
+				b.ExpectAck(cmd)
+				if write == test.ackedWrite {
+					b.inOutLock.Lock()
+					b.completeCmd("> SetT1 5")
+					b.inOutLock.Unlock()
+					break
+				}
+				var isResent bool
+				if cmd, isResent = nextResend(resent); !isResent {
+					break
+				}
+				if !cmd.resend {
+					t.Fatalf("%+v is not marked as a resend", cmd)
+				}
+				resends++
+			}
+
+			if resends != test.wantResends {
+				t.Errorf("resent %v times, want %v", resends, test.wantResends)
+			}
+			b.inOutLock.Lock()
+			waiting := len(b.timeouts) > 0 || b.awaitingAck("1")
+			b.inOutLock.Unlock()
+			if waiting {
+				t.Errorf("still waits for an acknowledgement")
+			}
+			if b.q.Len() != 0 {
+				t.Errorf("left %v commands in the queue", b.q.Len())
+			}
+		})
+	}
+}
+
+// nextResend waits for the command that timed out to be handed back to the
+// writer, it fails when the command was given up on instead
+func nextResend(resent chan Cmd) (Cmd, bool) {
+	select {
+	case cmd := <-resent:
+		return cmd, true
+	case <-time.After(200 * time.Millisecond):
+		return Cmd{}, false
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A raw capture file starts with a comment line describing the port,
+// followed by one line per chunk read from the port:
+//
+//	<microseconds since the capture started> <chunk as a quoted go string>
+//
+// The quoting keeps every byte, including the corrupt ones, while the file
+// stays readable.
+
+// rawCapture writes every chunk read from a port to a capture file
+type rawCapture struct {
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+}
+
+type captureChunk struct {
+	At   time.Duration
+	Data string
+}
+
+type replayReport struct {
+	Cmd    string
+	Desc   string
+	Port   string
+	File   string
+	Chunks int
+}
+
+var reInvalidFileChars = regexp.MustCompile("[\\\\/:*?\"<>|]")
+
+func captureDir() string {
+	return filepath.Join(env.DataDir, "captures")
+}
+
+// newRawCapture creates a new capture file for the given port
+func newRawCapture(conf *SerialConfig) (*rawCapture, error) {
+	start := time.Now()
+	filename := start.Format("2006-01-02 15.04.05") + " " + conf.Name + ".cap"
+	filename = reInvalidFileChars.ReplaceAllString(filename, "_")
+	file, err := os.Create(filepath.Join(captureDir(), filename))
+	if err != nil {
+		return nil, err
+	}
+	c := &rawCapture{file: file, writer: bufio.NewWriter(file), start: start}
+	fmt.Fprintf(c.writer, "# raw capture of %v at %v baud, started %v\n", conf.Name, conf.Baud, start.Format(time.RFC3339))
+	return c, nil
+}
+
+// write adds a chunk to the capture. It is timestamped with the monotonic
+// clock, so changes to the wall clock do not show up in the capture.
+func (c *rawCapture) write(data []byte) {
+	at := time.Since(c.start) / time.Microsecond
+	c.writer.WriteString(strconv.FormatInt(int64(at), 10) + " " + strconv.Quote(string(data)) + "\n")
+	c.writer.Flush()
+}
+
+func (c *rawCapture) Close() {
+	c.writer.Flush()
+	c.file.Close()
+}
+
+// readCapture reads all chunks from a capture file
+func readCapture(path string) ([]captureChunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	chunks := []captureChunk{}
+	scanner := bufio.NewScanner(file)
+	// a quoted chunk of 1024 bytes can take up to 4 times as much
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %v: missing data", lineNumber)
+		}
+		at, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid timestamp", lineNumber)
+		}
+		data, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid data", lineNumber)
+		}
+		chunks = append(chunks, captureChunk{time.Duration(at) * time.Microsecond, data})
+	}
+	return chunks, scanner.Err()
+}
+
+// replayCapture feeds a capture file through a fresh 3devo buffer flow as
+// if it came from a port. speed 1 replays at the original speed, higher
+// values replay faster and 0 replays as fast as possible. Replayed lines
+// are broadcast on the port "replay:<file name>" and never recorded.
+func replayCapture(path string, speed float64) error {
+	if speed < 0 {
+		return errors.New("speed can not be negative")
+	}
+	chunks, err := readCapture(path)
+	if err != nil {
+		return err
+	}
+
+	portname := "replay:" + filepath.Base(path)
+	bw := &Bufferflow3Devo{Name: "3devo", Port: portname}
+	bw.Init()
+	sendReplayReport("ReplayStart", "Started replaying the capture.", portname, path, len(chunks))
+
+	start := time.Now()
+	for _, chunk := range chunks {
+		if speed > 0 {
+			time.Sleep(time.Duration(float64(chunk.At)/speed) - time.Since(start))
+		}
+		bw.OnIncomingData(chunk.Data)
+	}
+	bw.Close()
+
+	sendReplayReport("ReplayDone", "Done replaying the capture.", portname, path, len(chunks))
+	return nil
+}
+
+// spReplay handles the replay command
+//
+//	replay <capture file> [speed]
+//
+// Only files in the captures directory can be replayed this way.
+func spReplay(c *connection, args []string) {
+	if len(args) < 2 {
+		spErr(c, "You did not specify a capture file to replay")
+		return
+	}
+	// capture file names contain spaces, so only a last argument that is
+	// a number is taken as the speed
+	filename := strings.Join(args[1:], " ")
+	speed := 1.0
+	if len(args) > 2 {
+		if value, err := strconv.ParseFloat(args[len(args)-1], 64); err == nil {
+			speed = value
+			filename = strings.Join(args[1:len(args)-1], " ")
+		}
+	}
+	path := filepath.Join(captureDir(), filepath.Base(filename))
+	if err := replayCapture(path, speed); err != nil {
+		log.Println("Could not replay capture " + path + ": " + err.Error())
+		spErr(c, "Could not replay capture: "+err.Error())
+	}
+}
+
+func sendReplayReport(cmd string, desc string, portname string, path string, chunks int) {
+	report := replayReport{
+		Cmd:    cmd,
+		Desc:   desc,
+		Port:   portname,
+		File:   filepath.Base(path),
+		Chunks: chunks,
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
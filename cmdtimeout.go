@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// A sendjson command can ask to be acknowledged within Timeout milliseconds.
+// When the device does not acknowledge it in time the command is written
+// again, up to Retries times, after which it is reported as failed and taken
+// off the queue so the commands after it can go out. The clock starts once
+// the command is written, acknowledgements of a command that failed are
+// ignored. Commands sent with sendnobuf are not tracked, their Timeout and
+// Retries are ignored.
+
+type cmdTimeout struct {
+	id      string
+	data    string
+	timeout time.Duration
+	retries int
+	attempt int
+	timer   *time.Timer
+}
+
+type cmdRetryReport struct {
+	Cmd     string
+	Id      string
+	P       string
+	Attempt int
+}
+
+type cmdFailedReport struct {
+	Cmd    string
+	Id     string
+	P      string
+	Reason string
+}
+
+// ExpectAck starts the acknowledgement timeout of a command that was just
+// written, or restarts it for a resend. Commands without an id or timeout
+// wait forever.
+func (b *Bufferflow3Devo) ExpectAck(cmd Cmd) {
+	if cmd.skippedBuffer || cmd.timeout <= 0 || cmd.id == "" {
+		return
+	}
+	b.inOutLock.Lock()
+	defer b.inOutLock.Unlock()
+
+	if !b.awaitingAck(cmd.id) {
+		// the device was quicker than us
+		return
+	}
+	if t, ok := b.timeouts[cmd.id]; ok {
+		if cmd.resend {
+			t.timer.Reset(t.timeout)
+			return
+		}
+		b.stopTimeout(cmd.id)
+	} else if cmd.resend {
+		// acknowledged or failed in the meantime
+		return
+	}
+	t := &cmdTimeout{
+		id:      cmd.id,
+		data:    cmd.data,
+		timeout: time.Duration(cmd.timeout) * time.Millisecond,
+		retries: cmd.retries,
+	}
+	t.timer = time.AfterFunc(t.timeout, func() { b.ackTimedOut(t) })
+	b.timeouts[cmd.id] = t
+}
+
+// awaitingAck tells whether a written command is still waiting for its
+// acknowledgement. It must be called with inOutLock held.
+func (b *Bufferflow3Devo) awaitingAck(id string) bool {
+	for _, cmd := range b.written {
+		if cmd.id == id && cmd.buffered && !cmd.dropped {
+			return true
+		}
+	}
+	return false
+}
+
+// ackTimedOut hands the command to the writer again or gives up on it
+func (b *Bufferflow3Devo) ackTimedOut(t *cmdTimeout) {
+	b.inOutLock.Lock()
+
+	if b.timeouts[t.id] != t {
+		// acknowledged or wiped in the meantime
+		b.inOutLock.Unlock()
+		return
+	}
+
+	if t.attempt < t.retries {
+		t.attempt++
+		b.inOutLock.Unlock()
+		log.Printf("Command %v on %v was not acknowledged, retry %v of %v\n", t.id, b.Port, t.attempt, t.retries)
+		auditCmd(b.Port, "Retry", t.id, t.data, "timeout")
+		report, err := json.Marshal(cmdRetryReport{Cmd: "Retry", Id: t.id, P: b.Port, Attempt: t.attempt})
+		if err == nil {
+			h.broadcastSys <- report
+		}
+		// the writer restarts the timer once it wrote the command again
+		if b.Resend != nil {
+			b.Resend(Cmd{data: t.data, id: t.id, willHandleCompleteResponse: true, timeout: int(t.timeout / time.Millisecond), retries: t.retries, resend: true})
+		}
+		return
+	}
+	defer b.inOutLock.Unlock()
+
+	log.Printf("Command %v on %v was not acknowledged, giving up\n", t.id, b.Port)
+	delete(b.timeouts, t.id)
+	b.dropWritten(t.id)
+	b.q.Remove(t.id)
+	auditCmd(b.Port, "Failed", t.id, t.data, "timeout")
+	report, err := json.Marshal(cmdFailedReport{Cmd: "Failed", Id: t.id, P: b.Port, Reason: "timeout"})
+	if err == nil {
+		h.broadcastSys <- report
+	}
+
+	if b.GetPaused() && !b.GetManualPaused() && b.q.Len() < b.BufferMax {
+		b.SetPaused(false, 1)
+	}
+}
+
+// stopTimeout forgets the timeout of an acknowledged command. It must be
+// called with inOutLock held.
+func (b *Bufferflow3Devo) stopTimeout(id string) {
+	if t, ok := b.timeouts[id]; ok {
+		t.timer.Stop()
+		delete(b.timeouts, id)
+	}
+}
+
+// stopTimeouts forgets all timeouts, i.e. when the queue got wiped. It must
+// be called with inOutLock held.
+func (b *Bufferflow3Devo) stopTimeouts() {
+	for id := range b.timeouts {
+		b.stopTimeout(id)
+	}
+}
+
+// resend hands a command to the writer again without queueing it
+func (p *serport) resend(cmd Cmd) {
+	defer func() {
+		// the port got closed in the meantime
+		if r := recover(); r != nil {
+			log.Println("Could not resend to " + p.portConf.Name + ", the port is closed")
+		}
+	}()
+	p.sendNoBuf <- cmd
+}
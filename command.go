@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"runtime"
+)
+
+// Besides the text commands the websocket takes json commands:
+//
+//	{"Version": 1, "Id": "42", "Method": "close", "Params": {"Port": "COM3"}}
+//
+// Every command gets exactly one Reply with the same Id, holding either the
+// Result or an Error. The reply goes to the client that sent the command
+// only, the events a command causes, like Open or RecordStart, still go to
+// every client. Methods are matched exactly, unlike the text commands.
+
+// commandVersion is the version of the json commands
+const commandVersion = 1
+
+// Error codes of a reply
+const (
+	errInvalidRequest     = "InvalidRequest"
+	errUnsupportedVersion = "UnsupportedVersion"
+	errUnknownMethod      = "UnknownMethod"
+	errInvalidParams      = "InvalidParams"
+	errFailed             = "Failed"
+)
+
+type commandRequest struct {
+	Version int
+	// returned as is in the reply, a string or a number
+	Id     json.RawMessage
+	Method string
+	Params json.RawMessage
+}
+
+type commandError struct {
+	Code    string
+	Message string
+}
+
+type commandReply struct {
+	Cmd     string
+	Version int
+	Id      json.RawMessage
+	Result  interface{}   `json:",omitempty"`
+	Error   *commandError `json:",omitempty"`
+}
+
+// reply is a message for a single connection
+type reply struct {
+	c       *connection
+	message []byte
+}
+
+// commandHandler runs a command of the client with its params, they are nil
+// when the request has none
+type commandHandler func(c *connection, params json.RawMessage) (interface{}, *commandError)
+
+var commandHandlers = map[string]commandHandler{
+	"version":     versionCommand,
+	"list":        listCommand,
+	"open":        openCommand,
+	"close":       closeCommand,
+	"send":        sendCommand,
+	"record":      recordCommand,
+	"snapshot":    snapshotCommand,
+	"alarms":      alarmsCommand,
+	"acknowledge": acknowledgeCommand,
+	"memstats":    memstatsCommand,
+	"subscribe":   subscribeCommand,
+	"unsubscribe": unsubscribeCommand,
+}
+
+// isCommandRequest tells whether a message of a client is a json command,
+// no text command starts with a brace
+func isCommandRequest(message []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(message), []byte("{"))
+}
+
+// handleCommand runs a json command of the client and replies to it
+func handleCommand(c *connection, message []byte) {
+	request := commandRequest{}
+	var result interface{}
+	var cmdErr *commandError
+	if err := json.Unmarshal(message, &request); err != nil {
+		cmdErr = &commandError{errInvalidRequest, "Could not parse command: " + err.Error()}
+	} else if request.Version != commandVersion {
+		cmdErr = &commandError{errUnsupportedVersion, "Version must be 1"}
+	} else if handler, found := commandHandlers[request.Method]; !found {
+		cmdErr = &commandError{errUnknownMethod, "Unknown method " + request.Method}
+	} else {
+		log.Printf("Command %v from a client\n", request.Method)
+		result, cmdErr = handler(c, request.Params)
+	}
+
+	id := request.Id
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	bm, err := json.Marshal(commandReply{Cmd: "Reply", Version: commandVersion, Id: id, Result: result, Error: cmdErr})
+	if err != nil {
+		log.Println("Could not reply to command: " + err.Error())
+		return
+	}
+	sendReply(c, bm)
+}
+
+// sendReply sends a message to a single connection
+func sendReply(c *connection, message []byte) {
+	h.replies <- reply{c, message}
+}
+
+// parseParams decodes the params of a command, missing params are zero
+func parseParams(params json.RawMessage, v interface{}) *commandError {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &commandError{errInvalidParams, "Invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+func failed(err error) *commandError {
+	return &commandError{errFailed, err.Error()}
+}
+
+func missingParam(name string) *commandError {
+	return &commandError{errInvalidParams, "Missing param " + name}
+}
+
+type portParams struct {
+	Port string
+}
+
+func versionCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	return map[string]string{"Version": version}, nil
+}
+
+func listCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := struct {
+		// also list the ports that are not allowed
+		All bool
+	}{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	return getPortList(p.All).SerialPorts, nil
+}
+
+// openCommand replies once the port is open or failed to open, the port
+// announces itself with an Open or OpenFail event too
+func openCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := struct {
+		Port string
+		// the options of the open text command, i.e. baud=115200
+		Options []string
+	}{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Port == "" {
+		return nil, missingParam("Port")
+	}
+	conf, serialNumber, err := openConfig(p.Port, p.Options)
+	if err != nil {
+		return nil, &commandError{errInvalidParams, "Invalid open command. " + err.Error()}
+	}
+	if _, isOpen := findPortByName(p.Port); isOpen {
+		return nil, &commandError{errFailed, "Port is already open or being opened."}
+	}
+	port := spStart(conf, serialNumber, false, nil)
+	if port == nil {
+		return nil, &commandError{errFailed, "Could not open port " + p.Port + "."}
+	}
+	go port.run()
+	return conf, nil
+}
+
+func closeCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := portParams{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Port == "" {
+		return nil, missingParam("Port")
+	}
+	if err := closePort(p.Port); err != nil {
+		return nil, failed(err)
+	}
+	return p, nil
+}
+
+func sendCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := struct {
+		Port string
+		Data string
+		// send straight to the device like sendnobuf
+		NoBuf bool
+	}{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Port == "" {
+		return nil, missingParam("Port")
+	}
+	if p.Data == "" {
+		return nil, missingParam("Data")
+	}
+	if err := writePort(p.Port, p.Data, !p.NoBuf); err != nil {
+		return nil, failed(err)
+	}
+	return portParams{p.Port}, nil
+}
+
+// recordCommand starts recording to the LogFile, or stops recording when
+// Stop is set
+func recordCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := struct {
+		Port    string
+		LogFile string
+		Stop    bool
+	}{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Port == "" {
+		return nil, missingParam("Port")
+	}
+	if p.Stop {
+		if err := stopRecording(p.Port); err != nil {
+			return nil, failed(err)
+		}
+		return recordingReport{Cmd: "RecordStop", Port: p.Port}, nil
+	}
+	if p.LogFile == "" {
+		return nil, missingParam("LogFile")
+	}
+	logFile, err := startRecording(p.Port, p.LogFile)
+	if err != nil {
+		return nil, failed(err)
+	}
+	return recordingReport{Cmd: "RecordStart", Port: p.Port, LogFile: logFile.UUID}, nil
+}
+
+func snapshotCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := portParams{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	snapshot, found := getPortSnapshot(p.Port)
+	if !found {
+		return nil, &commandError{errFailed, "We could not find the serial port " + p.Port}
+	}
+	return snapshot, nil
+}
+
+func alarmsCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := portParams{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	return activeAlarms(p.Port), nil
+}
+
+func acknowledgeCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	p := struct {
+		Id int
+	}{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	alarm, err := acknowledgeAlarm(p.Id)
+	if err != nil {
+		return nil, failed(err)
+	}
+	return alarm, nil
+}
+
+func memstatsCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return memStats, nil
+}
+
+func subscribeCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	return changeSubscription(c, params, true)
+}
+
+func unsubscribeCommand(c *connection, params json.RawMessage) (interface{}, *commandError) {
+	return changeSubscription(c, params, false)
+}
+
+// changeSubscription changes the topics of the client, the result holds its
+// topics afterwards
+func changeSubscription(c *connection, params json.RawMessage, subscribe bool) (interface{}, *commandError) {
+	p := struct {
+		Topic string
+	}{}
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Topic == "" {
+		return nil, missingParam("Topic")
+	}
+	topic, err := parseTopic(p.Topic)
+	if err != nil {
+		return nil, &commandError{errInvalidParams, err.Error()}
+	}
+	done := make(chan []string, 1)
+	h.subscriptions <- subscription{c: c, topic: topic, subscribe: subscribe, done: done}
+	return subscriptionsReport{Cmd: "Subscriptions", Topics: <-done}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHandleCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   string
+		wantId    string
+		wantError string
+	}{
+		{"version", `{"Version": 1, "Id": "42", "Method": "version"}`, `"42"`, ""},
+		{"numeric id", `{"Version": 1, "Id": 7, "Method": "version"}`, `7`, ""},
+		{"invalid json", `{"Version": 1,`, `null`, errInvalidRequest},
+		{"missing version", `{"Id": 1, "Method": "version"}`, `1`, errUnsupportedVersion},
+		{"newer version", `{"Version": 2, "Id": 1, "Method": "version"}`, `1`, errUnsupportedVersion},
+		{"methods match exactly", `{"Version": 1, "Id": 1, "Method": "Version"}`, `1`, errUnknownMethod},
+		{"unknown method", `{"Version": 1, "Id": 1, "Method": "reboot"}`, `1`, errUnknownMethod},
+		{"invalid params", `{"Version": 1, "Id": 1, "Method": "close", "Params": {"Port": 3}}`, `1`, errInvalidParams},
+		{"missing param", `{"Version": 1, "Id": 1, "Method": "close", "Params": {}}`, `1`, errInvalidParams},
+		{"invalid open options", `{"Version": 1, "Id": 1, "Method": "open", "Params": {"Port": "COM3", "Options": ["baud=fast"]}}`, `1`, errInvalidParams},
+		{"unknown port", `{"Version": 1, "Id": 1, "Method": "snapshot", "Params": {"Port": "nosuchport"}}`, `1`, errFailed},
+		{"unknown topic", `{"Version": 1, "Id": 1, "Method": "subscribe", "Params": {"Topic": "data"}}`, `1`, errInvalidParams},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &connection{}
+			handleCommand(c, []byte(test.request))
+			var r reply
+			select {
+			case r = <-h.replies:
+			case <-time.After(time.Second):
+				t.Fatal("no reply")
+			}
+			if r.c != c {
+				t.Errorf("replied to another connection")
+			}
+			got := commandReply{}
+			if err := json.Unmarshal(r.message, &got); err != nil {
+				t.Fatalf("could not parse reply %s: %v", r.message, err)
+			}
+			if got.Cmd != "Reply" || got.Version != commandVersion || string(got.Id) != test.wantId {
+				t.Errorf("replied %s, want a Reply to id %v", r.message, test.wantId)
+			}
+			gotError := ""
+			if got.Error != nil {
+				gotError = got.Error.Code
+			}
+			if gotError != test.wantError {
+				t.Errorf("replied %s, want error %q", r.message, test.wantError)
+			}
+		})
+	}
+}
+
+func TestOpenCommandFailsOnClaimedPort(t *testing.T) {
+	if !spClaimPort("claimed") {
+		t.Fatal("could not claim the port")
+	}
+	defer spReleasePort("claimed")
+	_, err := openCommand(&connection{}, json.RawMessage(`{"Port": "claimed"}`))
+	if err == nil || err.Code != errFailed {
+		t.Errorf("opening a claimed port returned %+v, want %v", err, errFailed)
+	}
+}
@@ -1,123 +1,108 @@
 package main
 
+import "github.com/3devo/dvconnector/models"
+
 const (
 	DevoUsbPID = "0C5B"
 	DevoUsbVID = "16D0"
 )
 
-type Column struct {
-	Validator string
+// defaultColumns seed the column catalogue in the database, they are the
+// columns of the incoming header format known before the catalogue existed
+var defaultColumns = []models.Column{
+	{Name: "Time", Type: "number", Precision: 0},
+	{Name: "SetT1", Type: "number", Precision: 2},
+	{Name: "Temp1", Type: "number", Precision: 2},
+	{Name: "dc1", Type: "number", Precision: 2},
+	{Name: "Err1", Type: "number", Precision: 2},
+	{Name: "SetT2", Type: "number", Precision: 2},
+	{Name: "Temp2", Type: "number", Precision: 2},
+	{Name: "dc2", Type: "number", Precision: 2},
+	{Name: "Err2", Type: "number", Precision: 2},
+	{Name: "SetT3", Type: "number", Precision: 2},
+	{Name: "Temp3", Type: "number", Precision: 2},
+	{Name: "dc3", Type: "number", Precision: 2},
+	{Name: "Err3", Type: "number", Precision: 2},
+	{Name: "SetT4", Type: "number", Precision: 2},
+	{Name: "Temp4", Type: "number", Precision: 2},
+	{Name: "dc4", Type: "number", Precision: 2},
+	{Name: "Err4", Type: "number", Precision: 2},
+	{Name: "intT4", Type: "number", Precision: 2},
+	{Name: "ExtCur", Type: "number", Precision: 2},
+	{Name: "ExtPWM", Type: "number", Precision: 2},
+	{Name: "ExtTmp", Type: "number", Precision: 2},
+	{Name: "Overht", Type: "number", Precision: 0},
+	{Name: "FAULT", Type: "number", Precision: 0},
+	{Name: "SetRPM", Type: "number", Precision: 2},
+	{Name: "RPM", Type: "number", Precision: 2},
+	{Name: "FT", Type: "number", Precision: 2},
+	{Name: "FTAVG", Type: "number", Precision: 2},
+	{Name: "Puller", Type: "number", Precision: 2},
+	{Name: "MemFree", Type: "number", Precision: 0},
+	{Name: "Status", Type: "text", Validator: `[a-zA-Z]+`},
+	{Name: "WndrSpd", Type: "number", Precision: 2},
+	{Name: "PosSpd", Type: "number", Precision: 2},
+	{Name: "Length", Type: "number", Precision: 2},
+	{Name: "Volume", Type: "number", Precision: 2},
+	{Name: "SpDia", Type: "number", Precision: 2},
+	{Name: "SpFill", Type: "number", Precision: 2},
+}
+
+// seedColumns fills an empty column catalogue with the default columns
+func seedColumns() error {
+	count, err := db.Count(&models.Column{})
+	if err != nil || count > 0 {
+		return err
+	}
+	for _, column := range defaultColumns {
+		column := column
+		if err := db.Save(&column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultFaultCodes seed the fault catalogue in the database, the Overht
+// flags and the FAULT bits are set per heater zone. Other bits are named
+// after their column until they are added through the REST API.
+var defaultFaultCodes = []models.FaultCode{
+	{Code: "Overht.0", Column: "Overht", Bit: 0, Name: "Heater zone 1 overheated", Severity: "critical"},
+	{Code: "Overht.1", Column: "Overht", Bit: 1, Name: "Heater zone 2 overheated", Severity: "critical"},
+	{Code: "Overht.2", Column: "Overht", Bit: 2, Name: "Heater zone 3 overheated", Severity: "critical"},
+	{Code: "Overht.3", Column: "Overht", Bit: 3, Name: "Heater zone 4 overheated", Severity: "critical"},
+	{Code: "FAULT.0", Column: "FAULT", Bit: 0, Name: "Heater zone 1 fault", Severity: "critical"},
+	{Code: "FAULT.1", Column: "FAULT", Bit: 1, Name: "Heater zone 2 fault", Severity: "critical"},
+	{Code: "FAULT.2", Column: "FAULT", Bit: 2, Name: "Heater zone 3 fault", Severity: "critical"},
+	{Code: "FAULT.3", Column: "FAULT", Bit: 3, Name: "Heater zone 4 fault", Severity: "critical"},
+}
+
+// seedFaultCodes fills an empty fault catalogue with the default fault
+// codes, afterwards the catalogue is only changed through the REST API
+func seedFaultCodes() error {
+	count, err := db.Count(&models.FaultCode{})
+	if err != nil || count > 0 {
+		return err
+	}
+	for _, faultCode := range defaultFaultCodes {
+		faultCode := faultCode
+		if err := db.Save(&faultCode); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// ApplicationConfiguration is the configuration that contains application specific logic like.
-// The incoming header format
-var KnownColumns = map[string]Column{
-	"Time": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SetT1": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Temp1": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"dc1": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Err1": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SetT2": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Temp2": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"dc2": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Err2": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SetT3": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Temp3": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"dc3": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Err3": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SetT4": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Temp4": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"dc4": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Err4": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"intT4": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"ExtCur": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"ExtPWM": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"ExtTmp": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Overht": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"FAULT": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SetRPM": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"RPM": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"FT": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"FTAVG": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Puller": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"MemFree": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Status": Column{
-		Validator: `[a-zA-Z]+`,
-	},
-	"WndrSpd": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"PosSpd": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Length": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"Volume": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SpDia": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
-	"SpFill": Column{
-		Validator: `-?[0-9]\d*(\.\d+)?`,
-	},
+// knownColumns returns the column catalogue keyed by name, it is seeded
+// once so columns deleted through the REST API stay unknown
+func knownColumns() map[string]models.Column {
+	columns := []models.Column{}
+	if db != nil {
+		db.All(&columns)
+	}
+	known := make(map[string]models.Column)
+	for _, column := range columns {
+		known[column.Name] = column
+	}
+	return known
 }
@@ -23,6 +23,12 @@ type connection struct {
 	send chan []byte
 
 	authenticated bool
+
+	// format of the telemetry, raw, typed or both
+	telemetry string
+
+	// topics the connection is subscribed to, used by the hub only
+	topics map[string]bool
 }
 
 func (c *connection) reader(env *utils.Env) {
@@ -31,19 +37,17 @@ func (c *connection) reader(env *utils.Env) {
 		if err != nil {
 			break
 		}
-		if c.authenticated {
-			h.broadcast <- message
+		if c.authenticated && isCommandRequest(message) {
+			// json commands are answered to this client only
+			go handleCommand(c, message)
+		} else if c.authenticated && isSubscriptionCommand(message) {
+			go spSubscribe(c, strings.Fields(string(message)))
+		} else if c.authenticated {
+			h.broadcast <- textCommand{c, message}
 		} else {
 			auth := strings.SplitN(string(message), " ", 2)
 			if len(auth) == 2 && auth[0] == "login" && !c.authenticated {
-				_, err := utils.ValidateJWTToken(auth[1])
-				if err != nil {
-					c.ws.WriteMessage(websocket.TextMessage, []byte("unauthorized"))
-					c.ws.Close()
-					return
-				}
-				blacklist := models.BlackListedToken{}
-				if err := env.Db.One("Token", auth[1], &blacklist); err == nil {
+				if !validLogin(env, auth[1]) {
 					c.ws.WriteMessage(websocket.TextMessage, []byte("unauthorized"))
 					c.ws.Close()
 					return
@@ -59,6 +63,16 @@ func (c *connection) reader(env *utils.Env) {
 	c.ws.Close()
 }
 
+// validLogin tells whether the token of a login command is valid and was
+// not logged out
+func validLogin(env *utils.Env, token string) bool {
+	if _, err := utils.ValidateJWTToken(token); err != nil {
+		return false
+	}
+	blacklist := models.BlackListedToken{}
+	return env.Db.One("Token", token, &blacklist) != nil
+}
+
 func (c *connection) writer(env *utils.Env) {
 	for message := range c.send {
 		if c.authenticated {
@@ -84,7 +98,7 @@ func wsHandle(env *utils.Env) httprouter.Handle {
 			return
 		}
 		//c := &connection{send: make(chan []byte, 256), ws: ws}
-		c := &connection{send: make(chan []byte, 256*10), ws: ws}
+		c := &connection{send: make(chan []byte, 256*10), ws: ws, telemetry: telemetryFormat(r.URL.Query().Get("telemetry")), topics: newTopics(r.URL.Query().Get("subscribe"))}
 		h.register <- c
 		defer func() { h.unregister <- c }()
 		go c.writer(env)
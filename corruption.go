@@ -0,0 +1,184 @@
+package main
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+// Lines that do not match the header are corrupt. Every port counts them in
+// memory since it was opened, the counts are part of its snapshot. While
+// recording the corrupt lines are also appended to a side file next to the
+// log file, which stays open, and counted in the models.CorruptionStats of
+// the log file. Those are saved every corruptionFlushInterval, and when the
+// recording stops or the port closes.
+//
+// In salvage mode the well-formed fields of a corrupt line are kept and the
+// bad ones are left empty, a line with a wrong number of fields is dropped.
+
+// generateColumnValidators returns a validator for every column of the
+// header, like generateRegexFromHeaders does for the whole line
+func generateColumnValidators(headers []string, knownColumns *map[string]models.Column) []*regexp.Regexp {
+	validators := []*regexp.Regexp{}
+	for _, headerName := range headers {
+		validator := models.TextValidator
+		if column, key := (*knownColumns)[headerName]; key {
+			validator = column.ValueValidator()
+		}
+		validators = append(validators, regexp.MustCompile("^(?:"+validator+")$"))
+	}
+	return validators
+}
+
+// salvageLine keeps the fields that match their column and empties the
+// others, it fails when the number of fields does not match the header
+func salvageLine(fields []string, validators []*regexp.Regexp) ([]string, bool) {
+	if len(fields) != len(validators) {
+		return nil, false
+	}
+	salvaged := make([]string, len(fields))
+	for i, field := range fields {
+		if validators[i].MatchString(field) {
+			salvaged[i] = field
+		}
+	}
+	return salvaged, true
+}
+
+// corruptionFlushInterval is how often the statistics of the log file are
+// saved while recording
+const corruptionFlushInterval = 10 * time.Second
+
+// corruptionCounts counts corrupt lines
+type corruptionCounts struct {
+	DroppedLines  int
+	DroppedBytes  int
+	SalvagedLines int
+	LongestBurst  int
+}
+
+func (counts *corruptionCounts) add(line string, salvaged bool, burst int) {
+	if salvaged {
+		counts.SalvagedLines++
+	} else {
+		counts.DroppedLines++
+		counts.DroppedBytes += len(line)
+	}
+	if burst > counts.LongestBurst {
+		counts.LongestBurst = burst
+	}
+}
+
+// corruptionTracker keeps the corrupt lines of a port in memory, guarded by
+// inOutLock
+type corruptionTracker struct {
+	// the corrupt lines since the port was opened, whether it records or not
+	session corruptionCounts
+	// the statistics and the open side file of the log file being recorded
+	// to, the statistics are saved when dirty
+	logFileUUID string
+	recorded    corruptionCounts
+	sideFile    *os.File
+	dirty       bool
+	flushedAt   time.Time
+}
+
+// recordCorrupt counts a corrupt line and, while recording, keeps it in the
+// side file and the statistics of the log file. burst is the number of
+// corrupt lines in a row up to this one. Called with inOutLock held.
+func (b *Bufferflow3Devo) recordCorrupt(line string, salvaged bool, burst int) {
+	b.corruption.session.add(line, salvaged, burst)
+
+	logFile := b.GetLogFile()
+	if logFile == nil {
+		return
+	}
+	if logFile.UUID != b.corruption.logFileUUID {
+		b.flushCorruption()
+		b.openCorruption(logFile)
+	}
+	if b.corruption.sideFile != nil {
+		if _, err := b.corruption.sideFile.WriteString(line + "\n"); err != nil {
+			log.Println("Can't write corrupt line of " + logFile.GetFileName() + ": " + err.Error())
+		}
+	}
+	b.corruption.recorded.add(line, salvaged, burst)
+	b.corruption.dirty = true
+	b.saveCorruptionIfDue()
+}
+
+// openCorruption continues the statistics of the log file and opens its side
+// file. Called with inOutLock held.
+func (b *Bufferflow3Devo) openCorruption(logFile *models.LogFile) {
+	stats := models.CorruptionStats{}
+	env.Db.One("LogFileUUID", logFile.UUID, &stats)
+	b.corruption.logFileUUID = logFile.UUID
+	b.corruption.recorded = corruptionCounts{stats.DroppedLines, stats.DroppedBytes, stats.SalvagedLines, stats.LongestBurst}
+	b.corruption.flushedAt = time.Now()
+	sideFile, err := logFile.OpenCorruptFile(env)
+	if err != nil {
+		log.Println("Can't open the corrupt lines of " + logFile.GetFileName() + ": " + err.Error())
+	}
+	b.corruption.sideFile = sideFile
+}
+
+// saveCorruptionIfDue saves the statistics of the log file when they changed
+// and were last saved corruptionFlushInterval ago. Called with inOutLock
+// held.
+func (b *Bufferflow3Devo) saveCorruptionIfDue() {
+	if b.corruption.dirty && time.Since(b.corruption.flushedAt) >= corruptionFlushInterval {
+		b.saveCorruption()
+	}
+}
+
+// saveCorruption saves the statistics of the log file. Called with inOutLock
+// held.
+func (b *Bufferflow3Devo) saveCorruption() {
+	recorded := b.corruption.recorded
+	stats := models.CorruptionStats{
+		LogFileUUID:   b.corruption.logFileUUID,
+		DroppedLines:  recorded.DroppedLines,
+		DroppedBytes:  recorded.DroppedBytes,
+		SalvagedLines: recorded.SalvagedLines,
+		LongestBurst:  recorded.LongestBurst,
+	}
+	if err := env.Db.Save(&stats); err != nil {
+		log.Println("Can't save corruption statistics of " + stats.LogFileUUID + ": " + err.Error())
+	}
+	b.corruption.dirty = false
+	b.corruption.flushedAt = time.Now()
+}
+
+// flushCorruption saves the statistics of the log file and closes its side
+// file. Called with inOutLock held.
+func (b *Bufferflow3Devo) flushCorruption() {
+	if b.corruption.dirty {
+		b.saveCorruption()
+	}
+	if b.corruption.sideFile != nil {
+		b.corruption.sideFile.Close()
+	}
+	b.corruption.logFileUUID = ""
+	b.corruption.recorded = corruptionCounts{}
+	b.corruption.sideFile = nil
+}
+
+// FlushCorruption saves the corruption statistics of the log file being
+// recorded to, used when the recording starts or stops.
+// go-routine safe.
+func (b *Bufferflow3Devo) FlushCorruption() {
+	b.inOutLock.Lock()
+	defer b.inOutLock.Unlock()
+	b.flushCorruption()
+}
+
+// Corruption returns the corrupt lines since the port was opened.
+// go-routine safe.
+func (b *Bufferflow3Devo) Corruption() corruptionCounts {
+	b.inOutLock.Lock()
+	defer b.inOutLock.Unlock()
+	return b.corruption.session
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+func TestSalvageLine(t *testing.T) {
+	columns := map[string]models.Column{
+		"Time":   {Name: "Time", Type: "number"},
+		"Temp1":  {Name: "Temp1", Type: "number"},
+		"Status": {Name: "Status", Type: "text", Validator: `[a-zA-Z]+`},
+	}
+	validators := generateColumnValidators([]string{"Time", "Temp1", "Status", "Note"}, &columns)
+	tests := []struct {
+		name     string
+		fields   []string
+		want     []string
+		salvaged bool
+	}{
+		{"well-formed line", []string{"12", "200.5", "Idle", "x"}, []string{"12", "200.5", "Idle", "x"}, true},
+		{"bad number", []string{"12", "20#.5", "Idle", "x"}, []string{"12", "", "Idle", "x"}, true},
+		{"bad text", []string{"12", "200.5", "Id1e", "x"}, []string{"12", "200.5", "", "x"}, true},
+		{"unknown columns take any text", []string{"12", "200.5", "Idle", "a b"}, []string{"12", "200.5", "Idle", "a b"}, true},
+		{"missing field", []string{"12", "200.5", "Idle"}, nil, false},
+		{"extra field", []string{"12", "200.5", "Idle", "x", "y"}, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, salvaged := salvageLine(test.fields, validators)
+			if salvaged != test.salvaged || !equalStrings(got, test.want) {
+				t.Errorf("salvageLine(%q) = %q, %v, want %q, %v", test.fields, got, salvaged, test.want, test.salvaged)
+			}
+		})
+	}
+}
+
+func TestCorruptionCounts(t *testing.T) {
+	counts := corruptionCounts{}
+	counts.add("bad", false, 1)
+	counts.add("worse", false, 2)
+	counts.add("salvaged", true, 3)
+	counts.add("bad", false, 1)
+	want := corruptionCounts{DroppedLines: 3, DroppedBytes: 11, SalvagedLines: 1, LongestBurst: 3}
+	if counts != want {
+		t.Errorf("counted %+v, want %+v", counts, want)
+	}
+}
@@ -1,13 +1,12 @@
 module github.com/3devo/dvconnector
 
+go 1.27.1
+
 require (
 	github.com/BurntSushi/toml v0.3.1
-	github.com/Sereal/Sereal v0.0.0-20190110175625-d6cf5b5efa3b // indirect
-	github.com/akavel/rsrc v0.0.0-20170831122431-f6a15ece2cfd // indirect
 	github.com/asdine/storm v2.1.2+incompatible
 	github.com/bob-thomas/configdir v0.0.0-20181219095810-37a1c27b6286
 	github.com/bob-thomas/go-serial v0.0.0-20180319113759-0f9c45f81e71
-	github.com/boltdb/bolt v1.3.1 // indirect
 	github.com/creack/goselect v0.0.0-20180501195510-58854f77ee8d
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/facchinm/go-serial v0.0.0-20150915155731-3cfbd2fab741
@@ -35,13 +34,11 @@ require (
 	github.com/gobuffalo/packr/v2 v2.0.0-rc.13
 	github.com/gobuffalo/syncx v0.0.0-20181120194010-558ac7de985f
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
 	github.com/google/uuid v0.0.0-20161128191214-064e2069ce9c
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e
 	github.com/gorilla/websocket v1.4.0
 	github.com/inconshreveable/mousetrap v1.0.0
 	github.com/joho/godotenv v1.3.0
-	github.com/josephspurrier/goversioninfo v0.0.0-20190123074621-6dac90912ffa // indirect
 	github.com/jtolds/gls v0.0.0-20181110203027-b4936e06046b
 	github.com/julienschmidt/httprouter v0.0.0-20181021223831-26a05976f9bf
 	github.com/kardianos/osext v0.0.0-20170510131534-ae77be60afb1
@@ -61,17 +58,136 @@ require (
 	github.com/skratchdot/open-golang v0.0.0-20160302144031-75fb7ed4208c
 	github.com/smartystreets/assertions v0.0.0-20180301161246-7678a5452ebe
 	github.com/smartystreets/goconvey v0.0.0-20170602164621-9e8dc3f972df
-	github.com/smartystreets/gunit v0.0.0-20180314194857-6f0d6275bdcd // indirect
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.3
 	github.com/tidwall/gjson v1.1.3
 	github.com/tidwall/match v1.0.1
 	github.com/urfave/negroni v0.3.0
-	github.com/vmihailenco/msgpack v4.0.1+incompatible // indirect
 	go.etcd.io/bbolt v1.3.0
 	golang.org/x/crypto v0.0.0-20181203042331-505ab145d0a9
 	golang.org/x/sys v0.0.0-20181218192612-074acd46bca6
 	golang.org/x/tools v0.0.0-20181218204010-d4971274fe38
-	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v9 v9.24.0
 )
+
+require (
+	github.com/Masterminds/semver v1.4.2 // indirect
+	github.com/Sereal/Sereal v0.0.0-20190110175625-d6cf5b5efa3b // indirect
+	github.com/ajg/form v0.0.0-20160822230020-523a5da1a92f // indirect
+	github.com/akavel/rsrc v0.0.0-20170831122431-f6a15ece2cfd // indirect
+	github.com/armon/consul-api v0.0.0-20180202201655-eb2c6b5be1b6 // indirect
+	github.com/boltdb/bolt v1.3.1 // indirect
+	github.com/cockroachdb/apd v1.1.0 // indirect
+	github.com/cockroachdb/cockroach-go v0.0.0-20181001143604-e0a95dfd547c // indirect
+	github.com/codegangsta/negroni v1.0.0 // indirect
+	github.com/coreos/etcd v3.3.10+incompatible // indirect
+	github.com/coreos/go-etcd v2.0.0+incompatible // indirect
+	github.com/coreos/go-semver v0.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dustin/go-humanize v1.0.0 // indirect
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/fatih/structs v1.1.0 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/go-sql-driver/mysql v1.4.0 // indirect
+	github.com/gobuffalo/buffalo v0.13.0 // indirect
+	github.com/gobuffalo/buffalo-pop v1.0.5 // indirect
+	github.com/gobuffalo/fizz v1.0.12 // indirect
+	github.com/gobuffalo/github_flavored_markdown v1.0.7 // indirect
+	github.com/gobuffalo/httptest v1.0.2 // indirect
+	github.com/gobuffalo/licenser v0.0.0-20181211173111-f8a311c51159 // indirect
+	github.com/gobuffalo/makr v1.1.5 // indirect
+	github.com/gobuffalo/mw-basicauth v1.0.3 // indirect
+	github.com/gobuffalo/mw-contenttype v0.0.0-20180802152300-74f5a47f4d56 // indirect
+	github.com/gobuffalo/mw-csrf v0.0.0-20180802151833-446ff26e108b // indirect
+	github.com/gobuffalo/mw-forcessl v0.0.0-20180802152810-73921ae7a130 // indirect
+	github.com/gobuffalo/mw-i18n v0.0.0-20180802152014-e3060b7e13d6 // indirect
+	github.com/gobuffalo/mw-paramlogger v0.0.0-20181005191442-d6ee392ec72e // indirect
+	github.com/gobuffalo/mw-tokenauth v0.0.0-20181001105134-8545f626c189 // indirect
+	github.com/gobuffalo/plush v3.7.32+incompatible // indirect
+	github.com/gobuffalo/plushgen v0.0.0-20181207152837-eedb135bd51b // indirect
+	github.com/gobuffalo/pop v4.8.4+incompatible // indirect
+	github.com/gobuffalo/release v1.1.6 // indirect
+	github.com/gobuffalo/shoulders v1.0.1 // indirect
+	github.com/gobuffalo/tags v2.0.15+incompatible // indirect
+	github.com/gobuffalo/uuid v2.0.5+incompatible // indirect
+	github.com/gobuffalo/validate v2.0.3+incompatible // indirect
+	github.com/gobuffalo/x v0.0.0-20181007152206-913e47c59ca7 // indirect
+	github.com/gofrs/uuid v3.1.0+incompatible // indirect
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/gorilla/context v1.1.1 // indirect
+	github.com/gorilla/mux v1.6.2 // indirect
+	github.com/gorilla/pat v0.0.0-20180118222023-199c85a7f6d1 // indirect
+	github.com/gorilla/securecookie v1.1.1 // indirect
+	github.com/gorilla/sessions v1.1.3 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/jackc/fake v0.0.0-20150926172116-812a484cc733 // indirect
+	github.com/jackc/pgx v3.2.0+incompatible // indirect
+	github.com/jmoiron/sqlx v0.0.0-20180614180643-0dae4fefe7c0 // indirect
+	github.com/josephspurrier/goversioninfo v0.0.0-20190123074621-6dac90912ffa // indirect
+	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/pty v1.1.3 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	github.com/lib/pq v1.0.0 // indirect
+	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/markbates/deplist v1.0.5 // indirect
+	github.com/markbates/going v1.0.2 // indirect
+	github.com/markbates/grift v1.0.4 // indirect
+	github.com/markbates/hmax v1.0.0 // indirect
+	github.com/markbates/inflect v1.0.4 // indirect
+	github.com/markbates/refresh v1.4.10 // indirect
+	github.com/markbates/sigtx v1.0.0 // indirect
+	github.com/markbates/willie v1.0.9 // indirect
+	github.com/mattn/go-colorable v0.0.9 // indirect
+	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/mattn/go-sqlite3 v1.9.0 // indirect
+	github.com/microcosm-cc/bluemonday v1.0.1 // indirect
+	github.com/mitchellh/go-homedir v1.0.0 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
+	github.com/monoculum/formam v0.0.0-20180901015400-4e68be1d79ba // indirect
+	github.com/nicksnyder/go-i18n v1.10.0 // indirect
+	github.com/onsi/ginkgo v1.7.0 // indirect
+	github.com/onsi/gomega v1.4.3 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/satori/go.uuid v1.2.0 // indirect
+	github.com/serenize/snaker v0.0.0-20171204205717-a683aaf2d516 // indirect
+	github.com/sergi/go-diff v1.0.0 // indirect
+	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24 // indirect
+	github.com/shurcooL/go v0.0.0-20180423040247-9e1955d9fb6e // indirect
+	github.com/shurcooL/go-goon v0.0.0-20170922171312-37c2f522c041 // indirect
+	github.com/shurcooL/highlight_diff v0.0.0-20170515013008-09bb4053de1b // indirect
+	github.com/shurcooL/highlight_go v0.0.0-20170515013102-78fb10f4a5f8 // indirect
+	github.com/shurcooL/octicon v0.0.0-20180602230221-c42b0e3b24d9 // indirect
+	github.com/shurcooL/sanitized_anchor_name v0.0.0-20170918181015-86672fcb3f95 // indirect
+	github.com/smartystreets/gunit v0.0.0-20180314194857-6f0d6275bdcd // indirect
+	github.com/sourcegraph/annotate v0.0.0-20160123013949-f4cad6c6324d // indirect
+	github.com/sourcegraph/syntaxhighlight v0.0.0-20170531221838-bd320f5d308e // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.3.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/viper v1.3.1 // indirect
+	github.com/stretchr/objx v0.1.1 // indirect
+	github.com/stretchr/testify v1.2.2 // indirect
+	github.com/ugorji/go/codec v0.0.0-20181204163529-d75b2dcb6bc8 // indirect
+	github.com/unrolled/secure v0.0.0-20181005190816-ff9db2ff917f // indirect
+	github.com/vmihailenco/msgpack v4.0.1+incompatible // indirect
+	github.com/xordataexchange/crypt v0.0.3-0.20170626215501-b2862e3d0a77 // indirect
+	golang.org/x/net v0.0.0-20181207154023-610586996380 // indirect
+	golang.org/x/sync v0.0.0-20181108010431-42b317875d0f // indirect
+	golang.org/x/text v0.3.0 // indirect
+	google.golang.org/appengine v1.2.0 // indirect
+	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
+	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	gopkg.in/errgo.v2 v2.1.0 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
+	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
+	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df // indirect
+	gopkg.in/mail.v2 v2.0.0-20180731213649-a0242b2233b4 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
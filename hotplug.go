@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// how long to wait after a hotplug event before enumerating the ports, so
+// the OS has time to fill in the device details
+const hotplugSettleTime = 500 * time.Millisecond
+
+type hotplugReport struct {
+	Cmd string
+	SpPortItem
+}
+
+// hotplugRescan triggers an immediate enumeration of the serial ports
+var hotplugRescan = make(chan bool, 1)
+
+// hotplugNotify asks the hotplug watcher to look at the ports again. It
+// never blocks.
+func hotplugNotify() {
+	select {
+	case hotplugRescan <- true:
+	default:
+	}
+}
+
+// hotplugWatch broadcasts PortAdded and PortRemoved whenever a serial port
+// appears or disappears. When autoOpen is set, new 3devo ports are opened
+// right away, other ports the allowlist lets through are only listed.
+func hotplugWatch(autoOpen bool) {
+	known := make(map[string]SpPortItem)
+	for _, item := range getPortList(false).SerialPorts {
+		known[strings.ToLower(item.Name)] = item
+	}
+	events := make(chan bool, 1)
+	go hotplugEvents(events)
+
+	for {
+		select {
+		case <-events:
+		case <-hotplugRescan:
+		}
+		time.Sleep(hotplugSettleTime)
+
+		current := make(map[string]SpPortItem)
+		for _, item := range getPortList(false).SerialPorts {
+			current[strings.ToLower(item.Name)] = item
+		}
+		for key, item := range current {
+			if _, found := known[key]; found {
+				continue
+			}
+			log.Println("Serial port got plugged in: ", item.Name)
+			sendHotplugReport("PortAdded", item)
+			if autoOpen && isDevoDevice(item) && !item.IsOpen && !isReconnecting(item.Name, item.SerialNumber) {
+				log.Println("Automatically opening ", item.Name)
+				go spHandlerOpen(item.Name, nil)
+			}
+		}
+		for key, item := range known {
+			if _, found := current[key]; !found {
+				log.Println("Serial port got removed: ", item.Name)
+				item.IsOpen = false
+				sendHotplugReport("PortRemoved", item)
+			}
+		}
+		known = current
+	}
+}
+
+func sendHotplugReport(cmd string, item SpPortItem) {
+	bytes, err := json.Marshal(hotplugReport{cmd, item})
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"syscall"
+	"time"
+)
+
+// how often the ports are enumerated when no uevents can be received
+const hotplugPollInterval = 5 * time.Second
+
+// hotplugEvents signals on events whenever the kernel reports a tty being
+// added or removed. When the uevent netlink socket is not available it falls
+// back to signalling periodically so the sysfs enumeration gets polled.
+func hotplugEvents(events chan bool) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1})
+		if err != nil {
+			syscall.Close(fd)
+		}
+	}
+	if err != nil {
+		log.Println("Could not listen for uevents, polling serial ports instead: ", err)
+		for {
+			time.Sleep(hotplugPollInterval)
+			signalHotplugEvent(events)
+		}
+	}
+	defer syscall.Close(fd)
+
+	buf := make([]byte, 16384)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.ENOBUFS {
+				// we might have missed an event, so look anyway
+				signalHotplugEvent(events)
+				continue
+			}
+			log.Println("Error reading uevents, stopped watching for hotplug events: ", err)
+			return
+		}
+		// the uevent is a list of zero separated KEY=value pairs
+		for _, field := range bytes.Split(buf[:n], []byte{0}) {
+			if bytes.Equal(field, []byte("SUBSYSTEM=tty")) {
+				signalHotplugEvent(events)
+				break
+			}
+		}
+	}
+}
+
+func signalHotplugEvent(events chan bool) {
+	select {
+	case events <- true:
+	default:
+	}
+}
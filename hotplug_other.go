@@ -0,0 +1,11 @@
+// +build !linux
+
+package main
+
+import "log"
+
+// hotplugEvents is only implemented on Linux, other platforms only rescan
+// when asked through hotplugNotify
+func hotplugEvents(events chan bool) {
+	log.Println("Hotplug events are not supported on this platform")
+}
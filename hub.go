@@ -22,11 +22,20 @@ type hub struct {
 	connections map[*connection]bool
 
 	// Inbound messages from the connections.
-	broadcast chan []byte
+	broadcast chan textCommand
 
 	// Inbound messages from the system
 	broadcastSys chan []byte
 
+	// Data lines of the ports in the raw and typed format
+	broadcastTelemetry chan telemetryBroadcast
+
+	// Replies to the json commands of a single connection
+	replies chan reply
+
+	// Topic changes of the connections
+	subscriptions chan subscription
+
 	// Register requests from the connections.
 	register chan *connection
 
@@ -36,14 +45,24 @@ type hub struct {
 
 var h = hub{
 	// buffered. go with 1000 cuz should never surpass that
-	broadcast:    make(chan []byte, 1000),
-	broadcastSys: make(chan []byte, 1000),
+	broadcast:          make(chan textCommand, 1000),
+	broadcastSys:       make(chan []byte, 1000),
+	broadcastTelemetry: make(chan telemetryBroadcast, 1000),
+	replies:            make(chan reply, 1000),
 	// non-buffered
 	//broadcast:    make(chan []byte),
 	//broadcastSys: make(chan []byte),
-	register:    make(chan *connection),
-	unregister:  make(chan *connection),
-	connections: make(map[*connection]bool),
+	register:      make(chan *connection),
+	unregister:    make(chan *connection),
+	subscriptions: make(chan subscription),
+	connections:   make(map[*connection]bool),
+}
+
+// textCommand is a text command of a connection, it is echoed to the
+// clients and its errors go back to the connection
+type textCommand struct {
+	c       *connection
+	message []byte
 }
 
 func (h *hub) run() {
@@ -53,6 +72,7 @@ func (h *hub) run() {
 			h.connections[c] = true
 			// send supported commands
 			c.send <- []byte("{\"Version\" : \"" + version + "\"} ")
+			sendSchemas(c)
 		case c := <-h.unregister:
 			delete(h.connections, c)
 			// put close in func cuz it was creating panics and want
@@ -69,17 +89,22 @@ func (h *hub) run() {
 				}()
 				close(c.send)
 			}()
-		case m := <-h.broadcast:
+		case cmd := <-h.broadcast:
+			m := cmd.message
 			//log.Print("Got a broadcast")
 			//log.Print(m)
 			//log.Print(len(m))
 			if len(m) > 0 {
 				//log.Print(string(m))
 				//log.Print(h.broadcast)
-				checkCmd(m)
+				checkCmd(cmd.c, m)
 				//log.Print("-----")
 
+				port := commandPort(m)
 				for c := range h.connections {
+					if !c.subscribed(topicCommands, port) {
+						continue
+					}
 					select {
 					case c.send <- m:
 						//log.Print("did broadcast to ")
@@ -97,7 +122,11 @@ func (h *hub) run() {
 			//log.Print(string(m))
 			//log.Print("-----")
 
+			kind, port := topicOf(m)
 			for c := range h.connections {
+				if !c.subscribed(kind, port) {
+					continue
+				}
 				select {
 				case c.send <- m:
 					//log.Print("did broadcast to ")
@@ -109,11 +138,51 @@ func (h *hub) run() {
 					go c.ws.Close()
 				}
 			}
+		case m := <-h.broadcastTelemetry:
+			kind, port := topicTelemetry, m.port
+			if m.typed == nil {
+				kind, port = topicOf(m.raw)
+			}
+		connections:
+			for c := range h.connections {
+				if !c.subscribed(kind, port) {
+					continue
+				}
+				for _, message := range m.messages(c.telemetry) {
+					select {
+					case c.send <- message:
+					default:
+						delete(h.connections, c)
+						close(c.send)
+						go c.ws.Close()
+						continue connections
+					}
+				}
+			}
+		case r := <-h.replies:
+			h.sendTo(r.c, r.message)
+		case s := <-h.subscriptions:
+			h.subscribe(s)
 		}
 	}
 }
 
-func checkCmd(m []byte) {
+// sendTo sends a message to a single connection, the connection can be gone
+// by now
+func (h *hub) sendTo(c *connection, message []byte) {
+	if !h.connections[c] {
+		return
+	}
+	select {
+	case c.send <- message:
+	default:
+		delete(h.connections, c)
+		close(c.send)
+		go c.ws.Close()
+	}
+}
+
+func checkCmd(c *connection, m []byte) {
 	//log.Print("Inside checkCmd")
 	s := string(m[:])
 	log.Print(s)
@@ -124,20 +193,19 @@ func checkCmd(m []byte) {
 		// remove newline
 		args := strings.Split(strings.TrimSpace(s), " ")
 		if len(args) < 2 {
-			go spErr("You did not specify a port in your open cmd")
+			go spErr(c, "You did not specify a port in your open cmd")
 			return
 		}
 		if len(args[1]) < 1 {
-			go spErr("You did not specify a serial port")
+			go spErr(c, "You did not specify a serial port")
 			return
 		}
 
-		dtrOn := false
-		if len(args) > 2 {
-			dtrOn = true
-		}
-
-		go spHandlerOpen(args[1], 115200, false, dtrOn)
+		// <port> can also be tcp://host:port or rfc2217://host:port
+		// open <port> [baud=115200] [databits=8] [parity=none] [stopbits=1]
+		//      [rts=on] [dtr=off] [flow=none] [stall=10] [salvage=off] [token=<jwt>] [reconnect] [capture]
+		// any other word pulses dtr, which resets the device
+		go spHandlerOpen(args[1], args[2:])
 
 	} else if strings.HasPrefix(sl, "close") {
 
@@ -147,24 +215,51 @@ func checkCmd(m []byte) {
 		//args := strings.Split(s, " ")
 		log.Printf("The split args for close:%v", args)
 		if len(args) > 1 {
-			go spClose(args[1])
+			go spClose(c, args[1])
 		} else {
-			go spErr("You did not specify a port to close")
+			go spErr(c, "You did not specify a port to close")
 		}
 
 	} else if strings.HasPrefix(sl, "sendjson") {
 		// will catch sendjson
 
-		go spWriteJson(s)
+		go spWriteJson(c, s)
 
 	} else if strings.HasPrefix(sl, "send") {
 		// will catch send and sendnobuf
 
 		//args := strings.Split(s, "send ")
-		go spWrite(s)
-
+		go spWrite(c, s)
+
+	} else if strings.HasPrefix(sl, "simulate") {
+		args := strings.Fields(s)
+		go spSimulate(c, args)
+	} else if strings.HasPrefix(sl, "record") {
+		args := strings.Fields(s)
+		go spRecord(c, args)
+	} else if strings.HasPrefix(sl, "replay") {
+		args := strings.Fields(s)
+		go spReplay(c, args)
+	} else if strings.HasPrefix(sl, "queue") || strings.HasPrefix(sl, "cancel") ||
+		strings.HasPrefix(sl, "pause") || strings.HasPrefix(sl, "resume") || strings.HasPrefix(sl, "flush") {
+		args := strings.Fields(s)
+		go spQueue(c, args)
+	} else if strings.HasPrefix(sl, "alarms") || strings.HasPrefix(sl, "acknowledge") {
+		args := strings.Fields(s)
+		go spAlarm(c, args)
+	} else if strings.HasPrefix(sl, "sequence") {
+		args := strings.Fields(s)
+		go spSequence(c, args)
+	} else if strings.HasPrefix(sl, "audit") {
+		args := strings.Fields(s)
+		go spAuditList(c, args)
+	} else if strings.HasPrefix(sl, "share") || strings.HasPrefix(sl, "unshare") {
+		args := strings.Fields(s)
+		go spShare(c, args)
 	} else if strings.HasPrefix(sl, "list") {
-		go spList()
+		// list all also shows the ports that are not allowed, for diagnostics
+		showAll := strings.TrimSpace(sl) == "list all"
+		go spList(showAll)
 		//go getListViaWmiPnpEntity()
 	} else if strings.HasPrefix(sl, "restart") {
 		restart()
@@ -177,7 +272,7 @@ func checkCmd(m []byte) {
 	} else if strings.HasPrefix(sl, "version") {
 		getVersion()
 	} else {
-		go spErr("Could not understand command.")
+		go spErr(c, "Could not understand command.")
 	}
 
 	//log.Print("Done with checkCmd")
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  string
+		valid bool
+	}{
+		{"*", "*", true},
+		{"telemetry", "telemetry", true},
+		{"Alarms", "alarms", true},
+		{"commands:COM3", "commands:com3", true},
+		{"system:/dev/ttyACM0", "system:/dev/ttyacm0", true},
+		{"telemetry:", "", false},
+		{"data", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		got, err := parseTopic(test.topic)
+		if got != test.want || (err == nil) != test.valid {
+			t.Errorf("parseTopic(%q) = %q, %v, want %q, valid %v", test.topic, got, err, test.want, test.valid)
+		}
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		query string
+		kind  string
+		port  string
+		want  bool
+	}{
+		{"", topicSystem, "", true},
+		{"telemetry", topicTelemetry, "COM3", true},
+		{"telemetry", topicSystem, "COM3", false},
+		{"commands:com3", topicCommands, "COM3", true},
+		{"commands:com3", topicCommands, "COM4", false},
+		{"commands:com3", topicCommands, "", false},
+		{"alarms, bogus", topicAlarms, "", true},
+		{"bogus", topicAlarms, "", false},
+	}
+	for _, test := range tests {
+		c := &connection{topics: newTopics(test.query)}
+		if got := c.subscribed(test.kind, test.port); got != test.want {
+			t.Errorf("%q subscribed to %v on %q is %v, want %v", test.query, test.kind, test.port, got, test.want)
+		}
+	}
+}
+
+func TestTopicOf(t *testing.T) {
+	tests := []struct {
+		message  string
+		wantKind string
+		wantPort string
+	}{
+		{`{"Cmd":"Open","Port":"COM3"}`, topicSystem, "COM3"},
+		{`{"P":"COM3","D":"Time\tTemp1"}`, topicTelemetry, "COM3"},
+		{`{"Cmd":"Schema","P":"COM3"}`, topicTelemetry, "COM3"},
+		{`{"Cmd":"SchemaChanged","P":"COM3"}`, topicTelemetry, "COM3"},
+		{`{"Cmd":"AlarmRaised","Port":"COM3"}`, topicAlarms, "COM3"},
+		{`{"Cmd":"Complete","P":"COM3"}`, topicSystem, "COM3"},
+		{`{"Error" : "Could not understand command."}`, topicSystem, ""},
+		{`Pre-closing serial port COM3`, topicSystem, ""},
+	}
+	for _, test := range tests {
+		kind, port := topicOf([]byte(test.message))
+		if kind != test.wantKind || port != test.wantPort {
+			t.Errorf("topicOf(%q) = %v, %q, want %v, %q", test.message, kind, port, test.wantKind, test.wantPort)
+		}
+	}
+}
+
+func TestCommandPort(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"open COM3 115200", "COM3"},
+		{"send COM3 SetT1 5", "COM3"},
+		{"SENDNOBUF COM3 info", "COM3"},
+		{`sendjson {"P":"COM3","Data":[{"D":"info\n"}]}`, "COM3"},
+		{"sendjson {broken", ""},
+		{"record start COM3 550e8400-e29b-41d4-a716-446655440000", "COM3"},
+		{"sequence status COM3", "COM3"},
+		{"simulate remove /dev/pts/3", "/dev/pts/3"},
+		{"simulate add 1234", ""},
+		{"acknowledge 12", ""},
+		{"close", ""},
+		{"list all", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := commandPort([]byte(test.command)); got != test.want {
+			t.Errorf("commandPort(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestCommandErrorsGoToSender(t *testing.T) {
+	tests := []struct {
+		command   string
+		wantError string
+	}{
+		{"bogus", "Could not understand command."},
+		{"open", "You did not specify a port in your open cmd"},
+		{"close", "You did not specify a port to close"},
+		{"queue nosuchport", "We could not find the serial port nosuchport"},
+		{"audit", "You did not specify a port to show the command trail of"},
+		{"record", "You did not specify a record action and port"},
+	}
+	for _, test := range tests {
+		c := &connection{topics: newTopics("telemetry")}
+		checkCmd(c, []byte(test.command))
+		select {
+		case r := <-h.replies:
+			if r.c != c || !strings.Contains(string(r.message), test.wantError) {
+				t.Errorf("%q replied %s, want %q to the sender", test.command, r.message, test.wantError)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%q did not reply %q", test.command, test.wantError)
+		}
+	}
+}
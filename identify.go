@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The Filament Maker prints its identity when it boots and answers the
+// info command with the same line:
+//
+//	Firmware: <version> Hardware: <revision> Serial: <machine serial>
+var reIdentity = regexp.MustCompile(`^Firmware:\s*(\S+)(?:\s+Hardware:\s*(\S+))?(?:\s+Serial:\s*(\S+))?\s*$`)
+
+var reVersionNumber = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// deviceIdentity is what the device told about itself
+type deviceIdentity struct {
+	Firmware      string
+	Hardware      string
+	MachineSerial string
+}
+
+// identityReport announces the identity of the device on an open port, the
+// Open report only holds it when the device answered before
+type identityReport struct {
+	Cmd           string
+	Port          string
+	Firmware      string
+	Hardware      string
+	MachineSerial string
+}
+
+// parseIdentity returns the identity when the line is an identity line
+func parseIdentity(line string) (deviceIdentity, bool) {
+	match := reIdentity.FindStringSubmatch(line)
+	if match == nil {
+		return deviceIdentity{}, false
+	}
+	return deviceIdentity{Firmware: match[1], Hardware: match[2], MachineSerial: match[3]}, true
+}
+
+// version returns the numeric part of the firmware version, i.e. 1.2 for
+// v1.2.3, or 0 when it has none
+func (identity deviceIdentity) version() float32 {
+	version, _ := strconv.ParseFloat(reVersionNumber.FindString(identity.Firmware), 32)
+	return float32(version)
+}
+
+// identify asks a Filament Maker who it is, it answers with an identity
+// line. Other devices are left alone as they might not like the command.
+func (p *serport) identify() {
+	if !isDevoPort(p.portConf.Name) {
+		return
+	}
+	if _, err := p.portIo.Write([]byte("info\n")); err != nil {
+		log.Println("Could not ask " + p.portConf.Name + " to identify: " + err.Error())
+	}
+}
+
+// isDevoPort tells whether the OS lists the port with the 3devo usb VID/PID
+func isDevoPort(portname string) bool {
+	list, _ := GetList()
+	metaports, _ := GetMetaList()
+	for _, item := range append(list, metaports...) {
+		if strings.EqualFold(item.Name, portname) {
+			return strings.EqualFold(item.IdVendor, DevoUsbVID) && strings.EqualFold(item.IdProduct, DevoUsbPID)
+		}
+	}
+	return false
+}
+
+// setIdentity stores the identity of the device, adds it to the log file
+// being recorded and announces it. Devices identify again when they reboot.
+func (p *serport) setIdentity(identity deviceIdentity) {
+	p.identityLock.Lock()
+	p.identity = identity
+	p.identityLock.Unlock()
+	if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok {
+		recordIdentity(bw, identity)
+	}
+	report, err := json.Marshal(identityReport{
+		Cmd:           "Identity",
+		Port:          p.portConf.Name,
+		Firmware:      identity.Firmware,
+		Hardware:      identity.Hardware,
+		MachineSerial: identity.MachineSerial,
+	})
+	if err == nil {
+		sendPortMessage(p.portConf.Name, report)
+	}
+}
+
+func (p *serport) getIdentity() deviceIdentity {
+	p.identityLock.Lock()
+	defer p.identityLock.Unlock()
+	return p.identity
+}
+
+// recordIdentity stores the device identity in the log file the buffer
+// flow records to
+func recordIdentity(bw *Bufferflow3Devo, identity deviceIdentity) {
+	logFile := bw.GetLogFile()
+	if logFile == nil || identity.Firmware == "" {
+		return
+	}
+	updated := *logFile
+	updated.Firmware = identity.Firmware
+	updated.Hardware = identity.Hardware
+	updated.MachineSerial = identity.MachineSerial
+	if updated == *logFile {
+		return
+	}
+	if err := db.Update(&updated); err != nil {
+		log.Println("Could not store the device identity in log file " + logFile.UUID + ": " + err.Error())
+		return
+	}
+	// only swap when we are still recording to the same log file
+	if bw.GetLogFile() == logFile {
+		bw.SetLogFile(&updated)
+	}
+}
@@ -46,6 +46,7 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"regexp"
 
 	"github.com/bob-thomas/configdir"
 	packr "github.com/gobuffalo/packr/v2"
@@ -61,6 +62,7 @@ import (
 	"io"
 	"io/ioutil"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/3devo/dvconnector/middleware"
@@ -93,6 +95,22 @@ var (
 	// to you be a bit more manageable
 	regExpFilter = flag.String("regex", "", "Regular expression to filter serial port list, i.e. -regex usb|acm")
 
+	// create simulated Filament Makers on a pty so the connector can be
+	// exercised without any hardware attached
+	simulate = flag.Int("simulate", 0, "Number of simulated Filament Makers to create (Linux only).")
+
+	// watch for serial ports being plugged in or removed
+	hotplug  = flag.Bool("hotplug", true, "Broadcast PortAdded/PortRemoved when serial ports are plugged in or removed (Linux only).")
+	autoOpen = flag.Bool("autoopen", false, "Automatically open 3devo ports when they are plugged in. Needs -hotplug.")
+
+	// keep the raw bytes read from the ports, to reproduce parser problems
+	captureRaw  = flag.Bool("capture", false, "Write the raw bytes read from every opened port to a capture file.")
+	replay      = flag.String("replay", "", "Capture file to replay through the 3devo parser at startup.")
+	replaySpeed = flag.Float64("replayspeed", 1, "Speed to replay the capture at, 0 replays as fast as possible.")
+
+	// make local serial ports available to DvConnectors on other machines
+	share = flag.String("share", "", "Share serial ports over tcp, on loopback unless a host is given, i.e. -share /dev/ttyACM0=:2217,COM3=rfc2217://0.0.0.0:2218")
+
 	// allow garbageCollection()
 	//isGC = flag.Bool("gc", false, "Is garbage collection on? Off by default.")
 	//isGC = flag.Bool("gc", true, "Is garbage collection on? Off by default.")
@@ -150,6 +168,7 @@ func onInit() {
 	webBox := packr.New("Frontend", "./frontend")
 	os.MkdirAll(filepath.Join(dataDir, "logs"), os.ModePerm)
 	os.MkdirAll(filepath.Join(dataDir, "notes"), os.ModePerm)
+	os.MkdirAll(filepath.Join(dataDir, "captures"), os.ModePerm)
 	os.MkdirAll(filepath.Join(dataDir, "database"), os.ModePerm)
 
 	var err error
@@ -163,7 +182,23 @@ func onInit() {
 	db.Init(&models.Sheet{})
 	db.Init(&models.Chart{})
 	db.Init(&models.LogFile{})
+	db.Init(&models.CorruptionStats{})
+	db.Init(&models.Anomaly{})
+	db.Init(&models.StatusTransition{})
+	db.Init(&models.FaultCode{})
+	if err := seedFaultCodes(); err != nil {
+		log.Println("Could not fill the fault catalogue: " + err.Error())
+	}
+	db.Init(&models.Alarm{})
+	clearStaleAlarms()
 	db.Init(&models.Config{})
+	db.Init(&models.PortSettings{})
+	db.Init(&models.Recording{})
+	db.Init(&models.Sequence{})
+	db.Init(&models.Column{})
+	if err := seedColumns(); err != nil {
+		log.Println("Could not fill the column catalogue: " + err.Error())
+	}
 	if newDatabase {
 		log.Println("filling database with default values")
 		FillDatabase(db)
@@ -171,6 +206,10 @@ func onInit() {
 	var users []models.User
 	var config models.Config
 	db.One("ID", 1, &config)
+	if config.AllowedDevices == nil {
+		// before the allowlist existed only 3devo machines were listed
+		config.AllowedDevices = defaultAllowedDevices()
+	}
 
 	db.All(&users)
 	if len(users) > 0 {
@@ -186,11 +225,32 @@ func onInit() {
 
 	defer db.Close()
 	env = &utils.Env{Db: db, Validator: validate, DataDir: dataDir, ConfigDir: configDir}
+	env.Broadcast = func(message []byte) {
+		h.broadcastSys <- message
+	}
+	env.Ports = func() interface{} {
+		return getPortList(false).SerialPorts
+	}
+	env.PortSnapshot = func(name string) (interface{}, bool) {
+		return getPortSnapshot(name)
+	}
 	/** Custom validators **/
 	validate.RegisterValidation("uuid", func(fl validator.FieldLevel) bool {
 		return utils.IsValidUUID(fl.Field().String())
 	})
 
+	validate.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+		_, err := regexp.Compile(fl.Field().String())
+		return err == nil
+	})
+
+	validate.RegisterValidation("required_if", utils.RequiredIf)
+
+	validate.RegisterValidation("sequence-condition", func(fl validator.FieldLevel) bool {
+		_, err := parseSequenceCondition(fl.Field().String())
+		return err == nil
+	})
+
 	validate.RegisterValidation("chart-exists", func(fl validator.FieldLevel) bool {
 		var chart models.Chart
 		err := db.One("UUID", fl.Field().String(), &chart)
@@ -252,6 +312,15 @@ func onInit() {
 		log.Printf("You specified a serial port regular expression filter: %v\n", *regExpFilter)
 	}
 
+	for i := 0; i < *simulate; i++ {
+		sim, err := startSimulator("")
+		if err != nil {
+			log.Println("Could not start simulator:", err)
+			break
+		}
+		log.Printf("Simulated Filament Maker %v is available on %v\n", sim.SerialNumber, sim.Name)
+	}
+
 	log.Println("Logs, Notes, Databases are stored at: ", env.DataDir)
 	//GetDarwinMeta()
 
@@ -297,6 +366,33 @@ func onInit() {
 	go h.run()
 	// launch our serial port routine
 	go sh.run()
+	// launch the hotplug watcher
+	if *hotplug {
+		go hotplugWatch(*autoOpen)
+	}
+	// share the serial ports over the network
+	for _, item := range strings.Split(*share, ",") {
+		if item == "" {
+			continue
+		}
+		portAddress := strings.SplitN(item, "=", 2)
+		if len(portAddress) != 2 {
+			log.Println("Invalid -share entry " + item + ", expected port=address")
+			continue
+		}
+		if err := startShare(portAddress[0], portAddress[1]); err != nil {
+			log.Println("Could not share port " + portAddress[0] + ": " + err.Error())
+		}
+	}
+	// replay a capture through the parser, the lines go to the websocket
+	// clients like the ones of a real port
+	if *replay != "" {
+		go func() {
+			if err := replayCapture(*replay, *replaySpeed); err != nil {
+				log.Println("Could not replay capture " + *replay + ": " + err.Error())
+			}
+		}()
+	}
 	// launch our dummy data routine
 	//go d.run()
 
@@ -307,6 +403,10 @@ func onInit() {
 	/**	LOG FILE ROUTING */
 	router.GET(restURL+"logFiles", middleware.AuthRequired(routing.GetAllLogFiles(env), env))
 	router.GET(restURL+"logFiles/:uuid", middleware.AuthRequired(routing.GetLogFile(env), env))
+	router.GET(restURL+"logFiles/:uuid/corruption", middleware.AuthRequired(routing.GetLogFileCorruption(env), env))
+	router.GET(restURL+"logFiles/:uuid/anomalies", middleware.AuthRequired(routing.GetLogFileAnomalies(env), env))
+	router.GET(restURL+"logFiles/:uuid/timeline", middleware.AuthRequired(routing.GetLogFileTimeline(env), env))
+	router.GET(restURL+"logFiles/:uuid/alarms", middleware.AuthRequired(routing.GetLogFileAlarms(env), env))
 	router.POST(restURL+"logFiles", middleware.AuthRequired(routing.CreateLogFile(env), env))
 	router.DELETE(restURL+"logFiles/:uuid", middleware.AuthRequired(routing.DeleteLogFile(env), env))
 	router.PUT(restURL+"logFiles/:uuid", middleware.AuthRequired(routing.UpdateLogFile(env), env))
@@ -332,6 +432,33 @@ func onInit() {
 	router.DELETE(restURL+"workspaces/:uuid", middleware.AuthRequired(routing.DeleteWorkspace(env), env))
 	router.PUT(restURL+"workspaces/:uuid", middleware.AuthRequired(routing.UpdateWorkspace(env), env))
 
+	/**	SEQUENCE ROUTING */
+	router.GET(restURL+"sequences", middleware.AuthRequired(routing.GetAllSequences(env), env))
+	router.GET(restURL+"sequences/:uuid", middleware.AuthRequired(routing.GetSequence(env), env))
+	router.POST(restURL+"sequences", middleware.AuthRequired(routing.CreateSequence(env), env))
+	router.DELETE(restURL+"sequences/:uuid", middleware.AuthRequired(routing.DeleteSequence(env), env))
+	router.PUT(restURL+"sequences/:uuid", middleware.AuthRequired(routing.UpdateSequence(env), env))
+
+	/**	COLUMN ROUTING */
+	router.GET(restURL+"columns", middleware.AuthRequired(routing.GetAllColumns(env), env))
+	router.GET(restURL+"columns/:name", middleware.AuthRequired(routing.GetColumn(env), env))
+	router.POST(restURL+"columns", middleware.AuthRequired(routing.CreateColumn(env), env))
+	router.DELETE(restURL+"columns/:name", middleware.AuthRequired(routing.DeleteColumn(env), env))
+	router.PUT(restURL+"columns/:name", middleware.AuthRequired(routing.UpdateColumn(env), env))
+
+	/**	ALARM ROUTING */
+	router.GET(restURL+"faultCodes", middleware.AuthRequired(routing.GetAllFaultCodes(env), env))
+	router.GET(restURL+"faultCodes/:code", middleware.AuthRequired(routing.GetFaultCode(env), env))
+	router.POST(restURL+"faultCodes", middleware.AuthRequired(routing.CreateFaultCode(env), env))
+	router.DELETE(restURL+"faultCodes/:code", middleware.AuthRequired(routing.DeleteFaultCode(env), env))
+	router.PUT(restURL+"faultCodes/:code", middleware.AuthRequired(routing.UpdateFaultCode(env), env))
+	router.GET(restURL+"alarms", middleware.AuthRequired(routing.GetActiveAlarms(env), env))
+	router.POST(restURL+"alarms/:id/acknowledge", middleware.AuthRequired(routing.AcknowledgeAlarm(env), env))
+
+	/**	PORT ROUTING */
+	router.GET(restURL+"ports", middleware.AuthRequired(routing.GetPorts(env), env))
+	router.GET(restURL+"ports/:name/snapshot", middleware.AuthRequired(routing.GetPortSnapshot(env), env))
+
 	/**	USER ROUTING */
 	router.POST(restURL+"users", routing.CreateUser(env))
 	router.DELETE(restURL+"users/:uuid", middleware.AuthRequired(routing.DeleteUser(env), env))
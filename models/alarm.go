@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/3devo/dvconnector/utils"
+)
+
+// Severities of alarms, from the most to the least severe
+var Severities = []string{"critical", "warning", "info"}
+
+// SeverityRank ranks a severity, 0 is the most severe
+func SeverityRank(severity string) int {
+	for rank, s := range Severities {
+		if s == severity {
+			return rank
+		}
+	}
+	return len(Severities)
+}
+
+// FaultCode names a bit of the FAULT bitmask or the Overht flags
+// swagger:model FaultCode
+type FaultCode struct {
+	// the column and the bit, i.e. FAULT.3, set from them when created
+	Code        string `storm:"id" json:"code"`
+	Column      string `json:"column" validate:"oneof=FAULT Overht"`
+	Bit         int    `json:"bit" validate:"gte=0,lte=31"`
+	Name        string `json:"name" validate:"required"`
+	Severity    string `json:"severity" validate:"oneof=critical warning info"`
+	Description string `json:"description"`
+}
+
+// FaultCodeOf returns the code of a bit of a column
+func FaultCodeOf(column string, bit int) string {
+	return column + "." + strconv.Itoa(bit)
+}
+
+// Alarm is a fault bit that was raised on a port. It is active until it is
+// cleared, alarms raised while recording belong to the log file.
+// swagger:model Alarm
+type Alarm struct {
+	ID          int    `storm:"id,increment" json:"id"`
+	Port        string `storm:"index" json:"port"`
+	LogFileUUID string `storm:"index" json:"logFileUuid,omitempty"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Severity    string `json:"severity"`
+	Description string `json:"description,omitempty"`
+	// device time of the line that raised the alarm
+	Time string `json:"time"`
+	// unix times, 0 while it did not happen
+	RaisedAt       int64 `json:"raisedAt"`
+	ClearedAt      int64 `json:"clearedAt"`
+	AcknowledgedAt int64 `json:"acknowledgedAt"`
+}
+
+// AlarmEvent is sent to the websocket clients when an alarm is raised,
+// cleared or acknowledged
+type AlarmEvent struct {
+	Cmd   string
+	Port  string
+	Alarm Alarm
+}
+
+// AcknowledgeAlarm marks the alarm as seen by an operator and tells the
+// clients, acknowledging it again does nothing. Only AcknowledgedAt is
+// written so the alarm can be cleared meanwhile.
+func AcknowledgeAlarm(env *utils.Env, id int) (Alarm, error) {
+	alarm := Alarm{}
+	if err := env.Db.One("ID", id, &alarm); err != nil {
+		return alarm, err
+	}
+	if alarm.AcknowledgedAt != 0 {
+		return alarm, nil
+	}
+	acknowledgedAt := time.Now().Unix()
+	if err := env.Db.UpdateField(&Alarm{ID: id}, "AcknowledgedAt", acknowledgedAt); err != nil {
+		return alarm, err
+	}
+	env.Db.One("ID", id, &alarm)
+	if env.Broadcast != nil {
+		event, _ := json.Marshal(AlarmEvent{Cmd: "AlarmAcknowledged", Port: alarm.Port, Alarm: alarm})
+		env.Broadcast(event)
+	}
+	return alarm, nil
+}
+
+// SortAlarms puts the most severe alarms first, the oldest first within a
+// severity
+func SortAlarms(alarms []Alarm) {
+	sort.SliceStable(alarms, func(i, j int) bool {
+		ri, rj := SeverityRank(alarms[i].Severity), SeverityRank(alarms[j].Severity)
+		if ri != rj {
+			return ri < rj
+		}
+		return alarms[i].RaisedAt < alarms[j].RaisedAt
+	})
+}
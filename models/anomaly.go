@@ -0,0 +1,20 @@
+package models
+
+// Anomaly is a period in which the values of a column were implausible
+// while recording to a log file
+//
+// swagger:model Anomaly
+type Anomaly struct {
+	ID          int    `storm:"id,increment" json:"id"`
+	LogFileUUID string `storm:"index" json:"logFileUuid"`
+	Column      string `json:"column"`
+	// min, max or change
+	Rule  string  `json:"rule"`
+	Limit float64 `json:"limit"`
+	// the value furthest past the limit, the change for a change
+	Worst float64 `json:"worst"`
+	// device time of the first and the last implausible sample
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Samples int    `json:"samples"`
+}
@@ -0,0 +1,39 @@
+package models
+
+// Validators of the column types, used when a column has no validator of
+// its own
+const (
+	NumberValidator = `-?[0-9]\d*(\.\d+)?`
+	TextValidator   = `[^\t]*`
+)
+
+// Column describes a column the Filament Maker prints in its header
+// swagger:model Column
+type Column struct {
+	Name string `storm:"id" json:"name" validate:"required,excludesall= \t"`
+	// number or text
+	Type        string `json:"type" validate:"oneof=number text"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+	// digits shown after the decimal point
+	Precision int `json:"precision" validate:"gte=0"`
+	// physical range of the values, unset when unknown
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// largest plausible change between two samples, unset when unknown
+	MaxChange *float64 `json:"maxChange,omitempty" validate:"omitempty,gte=0"`
+	// regular expression the values match, the one of the type when empty
+	Validator string `json:"validator" validate:"omitempty,regexp"`
+}
+
+// ValueValidator returns the regular expression the values of the column
+// match
+func (column *Column) ValueValidator() string {
+	if column.Validator != "" {
+		return column.Validator
+	}
+	if column.Type == "number" {
+		return NumberValidator
+	}
+	return TextValidator
+}
@@ -6,4 +6,17 @@ type Config struct {
 	ID          int  `storm:"id,increment" json:"id"`
 	OpenNetwork bool `json:"openNetwork"`
 	UserCreated bool `json:"userCreated"`
+	// Serial ports are listed when their usb VID/PID is allowed or their
+	// name matches one of the port patterns
+	AllowedDevices []AllowedDevice `json:"allowedDevices" validate:"dive"`
+	PortPatterns   []string        `json:"portPatterns" validate:"dive,regexp"`
+	// List every serial port regardless of the above, for diagnostics
+	ShowAllPorts bool `json:"showAllPorts"`
+}
+
+// AllowedDevice is the usb VID/PID pair of a device that is listed
+type AllowedDevice struct {
+	Vid  string `json:"vid" validate:"hexadecimal,len=4"`
+	Pid  string `json:"pid" validate:"hexadecimal,len=4"`
+	Name string `json:"name"`
 }
@@ -0,0 +1,17 @@
+package models
+
+// CorruptionStats counts the corrupt lines that came in while recording to
+// a log file
+//
+// swagger:model CorruptionStats
+type CorruptionStats struct {
+	LogFileUUID string `storm:"id" json:"logFileUuid"`
+	// lines that were dropped
+	DroppedLines int `json:"droppedLines"`
+	// bytes of the dropped lines
+	DroppedBytes int `json:"droppedBytes"`
+	// lines of which the well-formed fields were kept
+	SalvagedLines int `json:"salvagedLines"`
+	// most corrupt lines in a row
+	LongestBurst int `json:"longestBurst"`
+}
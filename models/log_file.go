@@ -5,9 +5,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/3devo/dvconnector/utils"
+	"github.com/asdine/storm/q"
 )
 
 // A logFile database model
@@ -19,6 +21,10 @@ type LogFile struct {
 	FileName  string `json:"filename"`
 	Timestamp int64  `json:"timestamp"`
 	HasNote   bool   `json:"hasNote"`
+	// Filament Maker the log was recorded from
+	Firmware      string `json:"firmware,omitempty"`
+	Hardware      string `json:"hardware,omitempty"`
+	MachineSerial string `json:"machineSerial,omitempty"`
 }
 
 // CreateLogFile creates a new logfile in the database
@@ -75,6 +81,11 @@ func (logFile *LogFile) AppendLog(logData string, env *utils.Env) error {
 	return nil
 }
 
+// OpenCorruptFile opens the side file of the log to append corrupt lines to
+func (logFile *LogFile) OpenCorruptFile(env *utils.Env) (*os.File, error) {
+	return os.OpenFile(filepath.Join(env.DataDir, "logs", logFile.GetCorruptFileName()), os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm)
+}
+
 // DeleteLogFile
 func (logFile *LogFile) DeleteLogFile(env *utils.Env) error {
 	err := os.Remove(filepath.Join(env.DataDir, "logs", logFile.GetFileName()))
@@ -83,6 +94,16 @@ func (logFile *LogFile) DeleteLogFile(env *utils.Env) error {
 		return err
 	}
 
+	err = os.Remove(filepath.Join(env.DataDir, "logs", logFile.GetCorruptFileName()))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	env.Db.DeleteStruct(&CorruptionStats{LogFileUUID: logFile.UUID})
+	env.Db.Select(q.Eq("LogFileUUID", logFile.UUID)).Delete(&Anomaly{})
+	env.Db.Select(q.Eq("LogFileUUID", logFile.UUID)).Delete(&StatusTransition{})
+	// alarms that are still active stay in the active list
+	env.Db.Select(q.Eq("LogFileUUID", logFile.UUID), q.Not(q.Eq("ClearedAt", int64(0)))).Delete(&Alarm{})
+
 	if logFile.HasNote {
 		err = os.Remove(filepath.Join(env.DataDir, "notes", logFile.GetFileName()))
 		if err != nil {
@@ -96,6 +117,34 @@ func (logFile *LogFile) DeleteLogFile(env *utils.Env) error {
 	return nil
 }
 
+// lastLineSize is how much of the end of a log file is read to find its last
+// line
+const lastLineSize = 4096
+
+// LastLine returns the last line of the log file and the time it was last
+// written to, the line is empty when the file has no lines
+func (logFile *LogFile) LastLine(env *utils.Env) (string, time.Time, error) {
+	f, err := os.Open(filepath.Join(env.DataDir, "logs", logFile.GetFileName()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	offset := info.Size() - lastLineSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil {
+		return "", time.Time{}, err
+	}
+	lines := strings.Split(strings.TrimRight(string(tail), "\r\n"), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), info.ModTime(), nil
+}
+
 // GetFileName returns the filename to use
 func (logFile *LogFile) GetFileName() string {
 	// Generate and store the filename on first use
@@ -112,3 +161,9 @@ func (logFile *LogFile) GetFileName() string {
 	}
 	return logFile.FileName
 }
+
+// GetCorruptFileName returns the filename of the side file that keeps the
+// corrupt lines
+func (logFile *LogFile) GetCorruptFileName() string {
+	return strings.TrimSuffix(logFile.GetFileName(), ".txt") + ".corrupt.txt"
+}
@@ -0,0 +1,17 @@
+package models
+
+// PortSettings are the serial line settings that were last used to open
+// the device with the given usb serial number
+type PortSettings struct {
+	SerialNumber string `storm:"id" json:"serialNumber"`
+	Baud         int    `json:"baud"`
+	DataBits     int    `json:"dataBits"`
+	Parity       string `json:"parity"`
+	StopBits     string `json:"stopBits"`
+	RtsOn        bool   `json:"rtsOn"`
+	FlowControl  string `json:"flowControl"`
+	// seconds, negative when the stall watchdog is off
+	StallTimeout float64 `json:"stallTimeout"`
+	// keep the well-formed fields of corrupt lines
+	Salvage bool `json:"salvage"`
+}
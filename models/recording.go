@@ -0,0 +1,10 @@
+package models
+
+// A recording database model
+//
+// This links a serial port to the log file its incoming lines are written to
+type Recording struct {
+	Port        string `storm:"id" json:"port"`
+	LogFileUUID string `json:"logFileUuid"`
+	StartedAt   int64  `json:"startedAt"`
+}
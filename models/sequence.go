@@ -0,0 +1,26 @@
+package models
+
+// SequenceStep is one step of an automation sequence
+//
+//	send:    writes Command to the port
+//	wait:    waits Seconds
+//	waitFor: waits until Condition holds for Seconds, i.e. Temp1 >= SetT1 - 2,
+//	         and fails after Timeout seconds when Timeout is set
+//	branch:  continues at the step Branches maps the current Status to, or
+//	         with the next step when the Status is not in there
+type SequenceStep struct {
+	Type      string         `json:"type" validate:"oneof=send wait waitFor branch"`
+	Command   string         `json:"command" validate:"required_if=Type send"`
+	Seconds   float64        `json:"seconds" validate:"gte=0,required_if=Type wait"`
+	Condition string         `json:"condition" validate:"required_if=Type waitFor,omitempty,sequence-condition"`
+	Timeout   float64        `json:"timeout" validate:"gte=0"`
+	Branches  map[string]int `json:"branches"`
+}
+
+// Sequence is a stored list of steps that can be run on a port
+// swagger:model Sequence
+type Sequence struct {
+	UUID  string         `storm:"id" json:"uuid" validate:"uuid"`
+	Title string         `json:"title" validate:"required"`
+	Steps []SequenceStep `json:"steps" validate:"min=1,dive"`
+}
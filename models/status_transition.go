@@ -0,0 +1,18 @@
+package models
+
+// StatusTransition is a change of the Status column recorded to a log file.
+// The first transition of a log has no From, it is the Status the recording
+// started in.
+//
+// swagger:model StatusTransition
+type StatusTransition struct {
+	ID          int    `storm:"id,increment" json:"id"`
+	LogFileUUID string `storm:"index" json:"logFileUuid"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	// device time of the first line with the new Status
+	Time      string `json:"time"`
+	Timestamp int64  `json:"timestamp"`
+	// seconds spent in From
+	Seconds float64 `json:"seconds"`
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	serial "github.com/bob-thomas/go-serial"
+)
+
+// Ports named tcp://host:port are a raw tcp stream to a serial port, like
+// the raw mode of ser2net. Ports named rfc2217://host:port speak the telnet
+// com port control option (RFC 2217), so the line settings of the open
+// command are applied to the remote serial port as well. The token option
+// of the open command logs in to a port shared by another DvConnector.
+
+const netDialTimeout = 5 * time.Second
+
+// telnet bytes used by RFC 2217
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptionBinary  = 0
+	telnetOptionSGA     = 3
+	telnetOptionComPort = 44
+)
+
+// RFC 2217 com port commands as sent by the client, the server answers with
+// the same command plus 100
+const (
+	comPortSetBaudrate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortSetControl  = 5
+	comPortPurgeData   = 12
+	comPortServerReply = 100
+)
+
+var rfc2217Parities = map[string]byte{"none": 1, "odd": 2, "even": 3, "mark": 4, "space": 5}
+var rfc2217StopBits = map[string]byte{"1": 1, "2": 2, "1.5": 3}
+var rfc2217FlowControls = map[string]byte{"none": 1, "xonxoff": 2, "rtscts": 3}
+
+// telnetDecoder strips the telnet commands from a stream and hands them to
+// the callbacks, the remaining bytes are the serial data
+type telnetDecoder struct {
+	state            int
+	verb             byte
+	sub              []byte
+	onCommand        func(verb byte, option byte)
+	onSubnegotiation func(option byte, data []byte)
+}
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSub
+	telnetStateSubIAC
+)
+
+func (d *telnetDecoder) decode(in []byte) []byte {
+	out := make([]byte, 0, len(in))
+	for _, b := range in {
+		switch d.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				d.state = telnetStateIAC
+			} else {
+				out = append(out, b)
+			}
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC:
+				out = append(out, b)
+				d.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				d.verb = b
+				d.state = telnetStateOption
+			case telnetSB:
+				d.sub = d.sub[:0]
+				d.state = telnetStateSub
+			default:
+				// NOP, GA and friends carry no meaning for a serial line
+				d.state = telnetStateData
+			}
+		case telnetStateOption:
+			if d.onCommand != nil {
+				d.onCommand(d.verb, b)
+			}
+			d.state = telnetStateData
+		case telnetStateSub:
+			if b == telnetIAC {
+				d.state = telnetStateSubIAC
+			} else {
+				d.sub = append(d.sub, b)
+			}
+		case telnetStateSubIAC:
+			if b == telnetSE {
+				if len(d.sub) > 0 && d.onSubnegotiation != nil {
+					d.onSubnegotiation(d.sub[0], d.sub[1:])
+				}
+				d.state = telnetStateData
+			} else {
+				d.sub = append(d.sub, b)
+				d.state = telnetStateSub
+			}
+		}
+	}
+	return out
+}
+
+// telnetEscape doubles the IAC bytes in serial data
+func telnetEscape(data []byte) []byte {
+	return bytes.Replace(data, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}, -1)
+}
+
+// comPortCommand builds the subnegotiation for a com port command
+func comPortCommand(command byte, value []byte) []byte {
+	msg := []byte{telnetIAC, telnetSB, telnetOptionComPort, command}
+	msg = append(msg, telnetEscape(value)...)
+	return append(msg, telnetIAC, telnetSE)
+}
+
+// isNetworkPort tells whether the port name is a tcp:// or rfc2217:// url
+func isNetworkPort(portname string) bool {
+	lower := strings.ToLower(portname)
+	return strings.HasPrefix(lower, "tcp://") || strings.HasPrefix(lower, "rfc2217://")
+}
+
+// netPort is a serial port on the other side of a tcp connection
+type netPort struct {
+	conn      net.Conn
+	rfc2217   bool
+	decoder   *telnetDecoder
+	writeLock *sync.Mutex
+}
+
+// openNetworkPort connects to a network port and applies the line
+// settings of conf when it speaks RFC 2217
+func openNetworkPort(conf *SerialConfig) (serial.Port, error) {
+	address := conf.Name[strings.Index(conf.Name, "://")+3:]
+	conn, err := net.DialTimeout("tcp", address, netDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Token != "" {
+		if err := netLogin(conn, conf.Token); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	p := &netPort{conn: conn, writeLock: &sync.Mutex{}}
+	if !strings.HasPrefix(strings.ToLower(conf.Name), "rfc2217://") {
+		return p, nil
+	}
+
+	p.rfc2217 = true
+	p.decoder = &telnetDecoder{onCommand: p.onCommand}
+	p.send([]byte{
+		telnetIAC, telnetWILL, telnetOptionComPort,
+		telnetIAC, telnetWILL, telnetOptionBinary,
+		telnetIAC, telnetDO, telnetOptionBinary,
+		telnetIAC, telnetWILL, telnetOptionSGA,
+		telnetIAC, telnetDO, telnetOptionSGA,
+	})
+	p.SetMode(conf.mode())
+	p.setFlowControl(conf.FlowControl)
+	p.SetDTR(conf.DtrOn)
+	if conf.FlowControl != "rtscts" {
+		p.SetRTS(conf.RtsOn)
+	}
+	if err := p.ResetInputBuffer(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// netLogin logs in to a shared port with the token
+func netLogin(conn net.Conn, token string) error {
+	conn.SetDeadline(time.Now().Add(netDialTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("login " + token + "\n")); err != nil {
+		return err
+	}
+	line, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != "Access granted" {
+		return errors.New("the shared port refused the token")
+	}
+	return nil
+}
+
+// readLine reads up to a newline without reading past it, the bytes after
+// it are serial data
+func readLine(conn net.Conn) (string, error) {
+	line := []byte{}
+	b := make([]byte, 1)
+	for len(line) < 4096 {
+		if _, err := conn.Read(b); err != nil {
+			return string(line), err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return string(line), errors.New("line too long")
+}
+
+// onCommand refuses the telnet options we do not know about
+func (p *netPort) onCommand(verb byte, option byte) {
+	if option == telnetOptionComPort || option == telnetOptionBinary || option == telnetOptionSGA {
+		return
+	}
+	switch verb {
+	case telnetWILL:
+		p.send([]byte{telnetIAC, telnetDONT, option})
+	case telnetDO:
+		p.send([]byte{telnetIAC, telnetWONT, option})
+	}
+}
+
+func (p *netPort) send(data []byte) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	_, err := p.conn.Write(data)
+	return err
+}
+
+func (p *netPort) comPort(command byte, value ...byte) error {
+	if !p.rfc2217 {
+		return nil
+	}
+	return p.send(comPortCommand(command, value))
+}
+
+func (p *netPort) SetMode(mode *serial.Mode) error {
+	if !p.rfc2217 {
+		return nil
+	}
+	conf := SerialConfig{Parity: "none", StopBits: "1"}
+	for name, parity := range parities {
+		if parity == mode.Parity {
+			conf.Parity = name
+		}
+	}
+	for name, stop := range stopBits {
+		if stop == mode.StopBits {
+			conf.StopBits = name
+		}
+	}
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(mode.BaudRate))
+	if err := p.comPort(comPortSetBaudrate, baud...); err != nil {
+		return err
+	}
+	if err := p.comPort(comPortSetDataSize, byte(mode.DataBits)); err != nil {
+		return err
+	}
+	if err := p.comPort(comPortSetParity, rfc2217Parities[conf.Parity]); err != nil {
+		return err
+	}
+	return p.comPort(comPortSetStopSize, rfc2217StopBits[conf.StopBits])
+}
+
+func (p *netPort) setFlowControl(flow string) error {
+	return p.comPort(comPortSetControl, rfc2217FlowControls[flow])
+}
+
+func (p *netPort) Read(buf []byte) (int, error) {
+	if !p.rfc2217 {
+		return p.conn.Read(buf)
+	}
+	// a chunk with only telnet commands must not look like an empty
+	// read, the reader takes fast empty reads for a lost port
+	for {
+		n, err := p.conn.Read(buf)
+		data := p.decoder.decode(buf[:n])
+		copy(buf, data)
+		if len(data) > 0 || err != nil {
+			return len(data), err
+		}
+	}
+}
+
+func (p *netPort) Write(data []byte) (int, error) {
+	if p.rfc2217 {
+		if err := p.send(telnetEscape(data)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	return p.conn.Write(data)
+}
+
+func (p *netPort) ResetInputBuffer() error {
+	return p.comPort(comPortPurgeData, 1)
+}
+
+func (p *netPort) ResetOutputBuffer() error {
+	return p.comPort(comPortPurgeData, 2)
+}
+
+func (p *netPort) SetDTR(dtr bool) error {
+	if dtr {
+		return p.comPort(comPortSetControl, 8)
+	}
+	return p.comPort(comPortSetControl, 9)
+}
+
+func (p *netPort) SetRTS(rts bool) error {
+	if rts {
+		return p.comPort(comPortSetControl, 11)
+	}
+	return p.comPort(comPortSetControl, 12)
+}
+
+func (p *netPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return nil, errors.New("modem status bits are not available on a network port")
+}
+
+func (p *netPort) Close() error {
+	return p.conn.Close()
+}
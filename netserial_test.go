@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTelnetDecoder(t *testing.T) {
+	tests := []struct {
+		name string
+		// the stream in the chunks it is read in
+		chunks   [][]byte
+		wantData []byte
+		// the commands and subnegotiations in the order they came in
+		wantEvents []string
+	}{
+		{"plain data", [][]byte{[]byte("Time\t12\n")}, []byte("Time\t12\n"), nil},
+		{"escaped IAC", [][]byte{{'a', telnetIAC, telnetIAC, 'b'}}, []byte{'a', telnetIAC, 'b'}, nil},
+		{"option command", [][]byte{{'a', telnetIAC, telnetWILL, telnetOptionComPort, 'b'}}, []byte("ab"), []string{"251 44"}},
+		{"command split over reads", [][]byte{{'a', telnetIAC}, {telnetDO}, {telnetOptionBinary, 'b'}}, []byte("ab"), []string{"253 0"}},
+		{"ignored command", [][]byte{{'a', telnetIAC, 241, 'b'}}, []byte("ab"), nil},
+		{"subnegotiation", [][]byte{{telnetIAC, telnetSB, telnetOptionComPort, comPortSetBaudrate + comPortServerReply, 0, 1, 194, 0, telnetIAC, telnetSE, 'x'}},
+			[]byte("x"), []string{"sub 44 [101 0 1 194 0]"}},
+		{"escaped IAC in subnegotiation", [][]byte{{telnetIAC, telnetSB, telnetOptionComPort, 1, telnetIAC, telnetIAC, telnetIAC}, {telnetSE}},
+			[]byte{}, []string{"sub 44 [1 255]"}},
+		{"empty subnegotiation", [][]byte{{telnetIAC, telnetSB, telnetIAC, telnetSE, 'x'}}, []byte("x"), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events := []string{}
+			d := &telnetDecoder{
+				onCommand: func(verb byte, option byte) {
+					events = append(events, fmt.Sprint(verb, " ", option))
+				},
+				onSubnegotiation: func(option byte, data []byte) {
+					events = append(events, fmt.Sprint("sub ", option, " ", data))
+				},
+			}
+			data := []byte{}
+			for _, chunk := range test.chunks {
+				data = append(data, d.decode(chunk)...)
+			}
+			if !bytes.Equal(data, test.wantData) {
+				t.Errorf("decoded %q, want %q", data, test.wantData)
+			}
+			if !equalStrings(events, test.wantEvents) {
+				t.Errorf("got events %q, want %q", events, test.wantEvents)
+			}
+		})
+	}
+}
+
+func TestComPortCommand(t *testing.T) {
+	tests := []struct {
+		command byte
+		value   []byte
+		want    []byte
+	}{
+		{comPortSetDataSize, []byte{8}, []byte{telnetIAC, telnetSB, telnetOptionComPort, comPortSetDataSize, 8, telnetIAC, telnetSE}},
+		{comPortSetBaudrate, []byte{0, 0, 0, telnetIAC}, []byte{telnetIAC, telnetSB, telnetOptionComPort, comPortSetBaudrate, 0, 0, 0, telnetIAC, telnetIAC, telnetIAC, telnetSE}},
+	}
+	for _, test := range tests {
+		if got := comPortCommand(test.command, test.value); !bytes.Equal(got, test.want) {
+			t.Errorf("comPortCommand(%v, %v) = %v, want %v", test.command, test.value, got, test.want)
+		}
+	}
+}
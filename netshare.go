@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	serial "github.com/bob-thomas/go-serial"
+)
+
+// A shared port makes a serial port of this machine available over tcp, so
+// another DvConnector can open it as tcp://host:port or rfc2217://host:port.
+// A port shared on rfc2217://address speaks RFC 2217, one shared on
+// tcp://address or a bare address passes the serial stream on untouched.
+// Without a host the share only listens on loopback. Clients log in with a
+// token like websocket clients do, by sending login <token> on a line of its
+// own, before they get the port. One client can use a shared port at a time
+// and while it does the port can not be opened locally.
+
+// the time a client gets to log in
+const shareLoginTimeout = 10 * time.Second
+
+type portShare struct {
+	portname string
+	address  string
+	// speak RFC 2217 instead of passing the stream on untouched
+	rfc2217  bool
+	listener net.Listener
+}
+
+type shareReport struct {
+	Cmd     string
+	Desc    string
+	Port    string
+	Address string
+	Remote  string `json:",omitempty"`
+}
+
+// Shared ports keyed by the lowercase port name
+var spShares = struct {
+	lock   *sync.Mutex
+	shares map[string]*portShare
+}{
+	lock:   &sync.Mutex{},
+	shares: make(map[string]*portShare),
+}
+
+// spShare handles the share and unshare commands
+//
+//	share <port> <[tcp://|rfc2217://][host]:port>
+//	unshare <port>
+func spShare(c *connection, args []string) {
+	if strings.ToLower(args[0]) == "unshare" {
+		if len(args) < 2 {
+			spErr(c, "You did not specify a port to unshare")
+			return
+		}
+		if !stopShare(args[1]) {
+			spErr(c, "Port "+args[1]+" is not shared")
+		}
+		return
+	}
+	if len(args) < 3 {
+		spErr(c, "You did not specify a port and address to share it on")
+		return
+	}
+	if err := startShare(args[1], args[2]); err != nil {
+		spErr(c, "Could not share port "+args[1]+": "+err.Error())
+	}
+}
+
+// startShare starts listening for clients of the given port
+func startShare(portname string, address string) error {
+	if isNetworkPort(portname) {
+		return errors.New("only local ports can be shared")
+	}
+	key := strings.ToLower(portname)
+	spShares.lock.Lock()
+	defer spShares.lock.Unlock()
+	if _, found := spShares.shares[key]; found {
+		return errors.New("port is already shared")
+	}
+	address, rfc2217, err := shareAddress(address)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	share := &portShare{portname: portname, address: listener.Addr().String(), rfc2217: rfc2217, listener: listener}
+	spShares.shares[key] = share
+	log.Printf("Sharing port %v on %v\n", portname, share.address)
+	sendShareReport("Shared", "Sharing the port over the network.", share, "")
+	go share.serve()
+	return nil
+}
+
+// shareAddress returns the address to listen on for a share address and
+// whether the share speaks RFC 2217, a share without a host listens on
+// loopback
+func shareAddress(address string) (string, bool, error) {
+	rfc2217 := false
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, "rfc2217://") {
+		rfc2217 = true
+		address = address[len("rfc2217://"):]
+	} else if strings.HasPrefix(lower, "tcp://") {
+		address = address[len("tcp://"):]
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", false, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), rfc2217, nil
+}
+
+// stopShare stops listening for clients of the given port. A connected
+// client is not disconnected. It returns false when the port was not shared.
+func stopShare(portname string) bool {
+	spShares.lock.Lock()
+	defer spShares.lock.Unlock()
+	key := strings.ToLower(portname)
+	share, found := spShares.shares[key]
+	if found {
+		share.listener.Close()
+		delete(spShares.shares, key)
+		sendShareReport("Unshared", "Stopped sharing the port.", share, "")
+	}
+	return found
+}
+
+func (share *portShare) serve() {
+	for {
+		conn, err := share.listener.Accept()
+		if err != nil {
+			return
+		}
+		go share.handle(conn)
+	}
+}
+
+// handle bridges a client to the serial port until either side goes away
+func (share *portShare) handle(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	if !share.login(conn) {
+		log.Printf("Refused %v on shared port %v, it did not log in\n", remote, share.portname)
+		return
+	}
+	if !spClaimPort(share.portname) {
+		log.Printf("Refused %v on shared port %v, it is in use\n", remote, share.portname)
+		return
+	}
+	defer spReleasePort(share.portname)
+
+	conf := defaultSerialConfig(share.portname)
+	conf.loadSettings(lookupSerialNumber(share.portname))
+	sp, err := openLocalPort(conf)
+	if err != nil {
+		log.Printf("Could not open shared port %v for %v: %v\n", share.portname, remote, err)
+		return
+	}
+	defer sp.Close()
+	sendShareReport("ShareConnected", "A client connected to the shared port.", share, remote)
+	defer sendShareReport("ShareDisconnected", "The client of the shared port disconnected.", share, remote)
+
+	client := &shareClient{conn: conn, port: sp, conf: conf, writeLock: &sync.Mutex{}, telnet: share.rfc2217}
+	if share.rfc2217 {
+		client.decoder = &telnetDecoder{onCommand: client.onCommand, onSubnegotiation: client.onSubnegotiation}
+	}
+
+	go func() {
+		// the serial port going away ends the session
+		buf := make([]byte, 1024)
+		for {
+			n, err := sp.Read(buf)
+			if n > 0 {
+				client.sendData(buf[:n])
+			}
+			if err != nil || n == 0 {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			data := buf[:n]
+			if client.decoder != nil {
+				data = client.decoder.decode(data)
+			}
+			if len(data) > 0 {
+				sp.Write(data)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// login checks the token the client logs in with and tells it whether it
+// got access
+func (share *portShare) login(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(shareLoginTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	line, err := readLine(conn)
+	auth := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if err != nil || len(auth) != 2 || auth[0] != "login" || !validLogin(env, auth[1]) {
+		conn.Write([]byte("unauthorized\n"))
+		return false
+	}
+	_, err = conn.Write([]byte("Access granted\n"))
+	return err == nil
+}
+
+// shareClient is the server side of RFC 2217 for one connected client
+type shareClient struct {
+	conn net.Conn
+	port serial.Port
+	conf *SerialConfig
+	// nil when the share passes the stream on untouched
+	decoder   *telnetDecoder
+	writeLock *sync.Mutex
+	// escape the serial data for telnet
+	telnet bool
+}
+
+func (c *shareClient) send(data []byte) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.conn.Write(data)
+}
+
+func (c *shareClient) sendData(data []byte) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.telnet {
+		data = telnetEscape(data)
+	}
+	c.conn.Write(data)
+}
+
+func (c *shareClient) onCommand(verb byte, option byte) {
+	known := option == telnetOptionComPort || option == telnetOptionBinary || option == telnetOptionSGA
+	switch {
+	case verb == telnetWILL && known:
+		c.send([]byte{telnetIAC, telnetDO, option})
+	case verb == telnetWILL:
+		c.send([]byte{telnetIAC, telnetDONT, option})
+	case verb == telnetDO && known && option != telnetOptionComPort:
+		c.send([]byte{telnetIAC, telnetWILL, option})
+	case verb == telnetDO:
+		c.send([]byte{telnetIAC, telnetWONT, option})
+	}
+}
+
+// onSubnegotiation applies a com port command to the serial port and
+// answers with the resulting setting. A value of 0 only asks for the
+// current setting.
+func (c *shareClient) onSubnegotiation(option byte, data []byte) {
+	if option != telnetOptionComPort || len(data) < 2 {
+		return
+	}
+	command, value := data[0], data[1:]
+	var reply []byte
+	switch command {
+	case comPortSetBaudrate, comPortSetDataSize, comPortSetParity, comPortSetStopSize:
+		c.setLine(command, value)
+		reply = c.lineSetting(command)
+	case comPortSetControl:
+		reply = c.setControl(value[0])
+	case comPortPurgeData:
+		if value[0] == 1 || value[0] == 3 {
+			c.port.ResetInputBuffer()
+		}
+		if value[0] == 2 || value[0] == 3 {
+			c.port.ResetOutputBuffer()
+		}
+		reply = value[:1]
+	default:
+		// signature, line state and friends are not supported
+		return
+	}
+	c.send(comPortCommand(command+comPortServerReply, reply))
+}
+
+// setLine changes the baud rate, data size, parity or stop size
+func (c *shareClient) setLine(command byte, value []byte) {
+	conf := *c.conf
+	switch command {
+	case comPortSetBaudrate:
+		if len(value) == 4 && binary.BigEndian.Uint32(value) != 0 {
+			conf.Baud = int(binary.BigEndian.Uint32(value))
+		}
+	case comPortSetDataSize:
+		if value[0] != 0 {
+			conf.DataBits = int(value[0])
+		}
+	case comPortSetParity:
+		conf.Parity = lookupRFC2217(rfc2217Parities, value[0], conf.Parity)
+	case comPortSetStopSize:
+		conf.StopBits = lookupRFC2217(rfc2217StopBits, value[0], conf.StopBits)
+	}
+	if conf == *c.conf {
+		return
+	}
+	if err := conf.validate(); err != nil {
+		log.Println("Ignoring invalid setting on shared port " + conf.Name + ": " + err.Error())
+		return
+	}
+	if err := c.port.SetMode(conf.mode()); err != nil {
+		log.Println("Could not change the settings of shared port " + conf.Name + ": " + err.Error())
+		return
+	}
+	*c.conf = conf
+}
+
+// lineSetting returns the current value of a line setting as RFC 2217
+// sends it
+func (c *shareClient) lineSetting(command byte) []byte {
+	switch command {
+	case comPortSetBaudrate:
+		baud := make([]byte, 4)
+		binary.BigEndian.PutUint32(baud, uint32(c.conf.Baud))
+		return baud
+	case comPortSetDataSize:
+		return []byte{byte(c.conf.DataBits)}
+	case comPortSetParity:
+		return []byte{rfc2217Parities[c.conf.Parity]}
+	}
+	return []byte{rfc2217StopBits[c.conf.StopBits]}
+}
+
+// setControl handles the flow control, dtr and rts part of SET-CONTROL
+func (c *shareClient) setControl(value byte) []byte {
+	switch value {
+	case 0:
+		return []byte{rfc2217FlowControls[c.conf.FlowControl]}
+	case 1, 2, 3:
+		flow := lookupRFC2217(rfc2217FlowControls, value, c.conf.FlowControl)
+		if port, ok := c.port.(flowController); ok && port.setFlowControl(flow) == nil {
+			c.conf.FlowControl = flow
+		}
+		return []byte{rfc2217FlowControls[c.conf.FlowControl]}
+	case 7, 8, 9:
+		if value != 7 && c.port.SetDTR(value == 8) == nil {
+			c.conf.DtrOn = value == 8
+		}
+		if c.conf.DtrOn {
+			return []byte{8}
+		}
+		return []byte{9}
+	case 10, 11, 12:
+		if value != 10 && c.port.SetRTS(value == 11) == nil {
+			c.conf.RtsOn = value == 11
+		}
+		if c.conf.RtsOn {
+			return []byte{11}
+		}
+		return []byte{12}
+	}
+	return []byte{value}
+}
+
+// lookupRFC2217 returns the name of an RFC 2217 setting value, or current
+// when the value is unknown or 0
+func lookupRFC2217(values map[string]byte, value byte, current string) string {
+	for name, v := range values {
+		if v == value {
+			return name
+		}
+	}
+	return current
+}
+
+func sendShareReport(cmd string, desc string, share *portShare, remote string) {
+	report := shareReport{
+		Cmd:     cmd,
+		Desc:    desc,
+		Port:    share.portname,
+		Address: share.address,
+		Remote:  remote,
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
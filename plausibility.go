@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+// A line can match the header while holding impossible values, i.e. after a
+// sensor fault. The min, max and maxChange of the column catalogue are
+// checked on every data line, violations are sent along with the line as
+// anomalies and stored with the log file while recording. The line itself
+// is kept.
+
+// anomaly is a value of a line that violates a rule of its column
+type anomaly struct {
+	Column string
+	// min, max or change
+	Rule string
+	// the value, or the change since the previous sample for a change
+	Value float64
+	Limit float64
+}
+
+// excess tells how far the value is past the limit
+func (a anomaly) excess() float64 {
+	switch a.Rule {
+	case "min":
+		return a.Limit - a.Value
+	case "change":
+		return math.Abs(a.Value) - a.Limit
+	}
+	return a.Value - a.Limit
+}
+
+type plausibilityRule struct {
+	index     int
+	column    string
+	min       *float64
+	max       *float64
+	maxChange *float64
+}
+
+// plausibility checks the lines of one header, it is used by the input loop
+// only
+type plausibility struct {
+	rules []plausibilityRule
+	// last value of a column within its bounds, keyed by index
+	previous map[int]float64
+
+	// anomalies of the log file that are still going on, keyed by column
+	// and rule
+	logFileUUID string
+	episodes    map[string]*models.Anomaly
+}
+
+func newPlausibility(header []string, knownColumns map[string]models.Column) *plausibility {
+	p := &plausibility{previous: make(map[int]float64), episodes: make(map[string]*models.Anomaly)}
+	for i, name := range header {
+		column, found := knownColumns[name]
+		if !found || column.Type != "number" {
+			continue
+		}
+		if column.Min == nil && column.Max == nil && column.MaxChange == nil {
+			continue
+		}
+		p.rules = append(p.rules, plausibilityRule{
+			index:     i,
+			column:    name,
+			min:       column.Min,
+			max:       column.Max,
+			maxChange: column.MaxChange,
+		})
+	}
+	return p
+}
+
+// check returns the anomalies of a data line
+func (p *plausibility) check(line []string) []anomaly {
+	anomalies := []anomaly{}
+	for _, rule := range p.rules {
+		if rule.index >= len(line) {
+			continue
+		}
+		// a salvaged line can miss the value
+		value, err := strconv.ParseFloat(line[rule.index], 64)
+		if err != nil {
+			continue
+		}
+		inBounds := true
+		if rule.min != nil && value < *rule.min {
+			anomalies = append(anomalies, anomaly{rule.column, "min", value, *rule.min})
+			inBounds = false
+		}
+		if rule.max != nil && value > *rule.max {
+			anomalies = append(anomalies, anomaly{rule.column, "max", value, *rule.max})
+			inBounds = false
+		}
+		if !inBounds {
+			// an impossible value is no base for the next change
+			continue
+		}
+		previous, hasPrevious := p.previous[rule.index]
+		if rule.maxChange != nil && hasPrevious && math.Abs(value-previous) > *rule.maxChange {
+			anomalies = append(anomalies, anomaly{rule.column, "change", value - previous, *rule.maxChange})
+		}
+		p.previous[rule.index] = value
+	}
+	return anomalies
+}
+
+// record stores the anomalies of a line with the log file, anomalies of a
+// column that go on over several lines are one models.Anomaly
+func (p *plausibility) record(logFile *models.LogFile, anomalies []anomaly, deviceTime string) {
+	if logFile == nil || logFile.UUID != p.logFileUUID {
+		p.episodes = make(map[string]*models.Anomaly)
+		if logFile == nil {
+			p.logFileUUID = ""
+			return
+		}
+		p.logFileUUID = logFile.UUID
+	}
+
+	episodes := make(map[string]*models.Anomaly)
+	for _, a := range anomalies {
+		key := a.Column + " " + a.Rule
+		episode, found := p.episodes[key]
+		if !found {
+			log.Printf("Implausible %v of %v in %v: %v, limit %v\n", a.Rule, a.Column, logFile.GetFileName(), a.Value, a.Limit)
+			episode = &models.Anomaly{
+				LogFileUUID: logFile.UUID,
+				Column:      a.Column,
+				Rule:        a.Rule,
+				Limit:       a.Limit,
+				Worst:       a.Value,
+				Start:       deviceTime,
+			}
+		}
+		episode.End = deviceTime
+		episode.Samples++
+		if a.excess() > (anomaly{Rule: episode.Rule, Value: episode.Worst, Limit: episode.Limit}).excess() {
+			episode.Worst = a.Value
+		}
+		if err := env.Db.Save(episode); err != nil {
+			log.Println("Can't save anomaly of " + logFile.GetFileName() + ": " + err.Error())
+		}
+		episodes[key] = episode
+	}
+	p.episodes = episodes
+}
@@ -131,6 +131,34 @@ func (q *Queue) Peek() (string, string) {
 	return n.data, n.id
 }
 
+//	Removes the oldest value with the given id from the queue.
+//	Returns false when no value has that id.
+//	Note: this function does mutate the queue.
+//	go-routine safe.
+func (q *Queue) Remove(id string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var prev *queuenode
+	for n := q.head; n != nil; prev, n = n, n.next {
+		if n.id != id {
+			continue
+		}
+		if prev == nil {
+			q.head = n.next
+		} else {
+			prev.next = n.next
+		}
+		if q.tail == n {
+			q.tail = prev
+		}
+		q.count--
+		q.lenOfCmds -= len(n.data)
+		return true
+	}
+	return false
+}
+
 //	Returns a read value at the front of the queue.
 //	i.e. the oldest value in the queue.
 //	Note: this function does NOT mutate the queue.
@@ -145,6 +173,21 @@ func (q *Queue) Delete() {
 	q.lenOfCmds = 0
 }
 
+//	Returns the values and ids in the queue, oldest first.
+//	go-routine safe.
+func (q *Queue) Items() ([]string, []string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	data := []string{}
+	ids := []string{}
+	for n := q.head; n != nil; n = n.next {
+		data = append(data, n.data)
+		ids = append(ids, n.id)
+	}
+	return data, ids
+}
+
 func (q *Queue) DebugStr() string {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// The commands of a port wait in sendBuffered until the buffer flow lets
+// them go. Channels can not be looked into, so pendingCmds keeps a copy of
+// what is in there in the same order, which is also where a command is
+// cancelled before it reaches the buffer flow.
+
+type pendingCmd struct {
+	Id string
+	D  string
+}
+
+type pendingCmds struct {
+	lock *sync.Mutex
+	cmds []pendingCmd
+}
+
+type queueReport struct {
+	Cmd    string
+	P      string
+	QCnt   int
+	Paused bool
+	// written to the device and waiting for an acknowledgement, the last
+	// one can also be waiting to be written
+	Waiting []pendingCmd
+	// not handed to the buffer flow yet
+	Pending []pendingCmd
+}
+
+type bufferReport struct {
+	Cmd  string
+	Id   string `json:",omitempty"`
+	P    string
+	QCnt int
+}
+
+func newPendingCmds() *pendingCmds {
+	return &pendingCmds{lock: &sync.Mutex{}}
+}
+
+func (pc *pendingCmds) push(id string, data string) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.cmds = append(pc.cmds, pendingCmd{Id: id, D: data})
+}
+
+// take is called when a command comes out of sendBuffered, it returns false
+// when the command got cancelled in the meantime
+func (pc *pendingCmds) take(id string, data string) bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if len(pc.cmds) == 0 || pc.cmds[0].Id != id || pc.cmds[0].D != data {
+		return false
+	}
+	pc.cmds = pc.cmds[1:]
+	return true
+}
+
+// cancel removes the oldest command with the id
+func (pc *pendingCmds) cancel(id string) bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	for i, cmd := range pc.cmds {
+		if cmd.Id == id {
+			pc.cmds = append(pc.cmds[:i], pc.cmds[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (pc *pendingCmds) list() []pendingCmd {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	return append([]pendingCmd{}, pc.cmds...)
+}
+
+func (pc *pendingCmds) clear() {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.cmds = nil
+}
+
+// spQueue handles the commands to look into and control the queue of a port
+//
+//	queue <port>
+//	cancel <port> <id>
+//	pause <port>
+//	resume <port>
+//	flush <port>
+//
+// Sending #pause, #resume or #flush to the port does the same in band.
+func spQueue(c *connection, args []string) {
+	if len(args) < 2 {
+		spErr(c, "You did not specify a port")
+		return
+	}
+	p, isFound := findPortByName(args[1])
+	if !isFound {
+		spErr(c, "We could not find the serial port "+args[1]+" that you were trying to control the queue of.")
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "queue":
+		sendQueueReport(c, p)
+	case "cancel":
+		if len(args) < 3 {
+			spErr(c, "You did not specify the id of the command to cancel")
+			return
+		}
+		spCancel(c, p, args[2])
+	case "pause":
+		p.pauseBuffer()
+	case "resume":
+		p.resumeBuffer()
+	case "flush":
+		p.wipeBuffer()
+	}
+}
+
+// spCancel drops a command that was not written to the device yet
+func spCancel(c *connection, p *serport, id string) {
+	cancelled := p.pending.cancel(id)
+	if !cancelled {
+		if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok {
+			// BlockUntilReady lowers itemsInBuffer when it drops the command
+			cancelled = bw.Cancel(id)
+		}
+	}
+	if !cancelled {
+		spErr(c, "Command "+id+" on "+p.portConf.Name+" is not waiting to be written")
+		return
+	}
+	auditCmd(p.portConf.Name, "Cancelled", id, "", "")
+	sendBufferReport("Cancelled", id, p)
+}
+
+func sendQueueReport(c *connection, p *serport) {
+	report := queueReport{
+		Cmd:     "Queue",
+		P:       p.portConf.Name,
+		QCnt:    p.itemsInBuffer,
+		Paused:  p.bufferwatcher.GetManualPaused(),
+		Waiting: []pendingCmd{},
+		Pending: p.pending.list(),
+	}
+	if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok {
+		data, ids := bw.Waiting()
+		for i := range data {
+			report.Waiting = append(report.Waiting, pendingCmd{Id: ids[i], D: data[i]})
+		}
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		sendReply(c, bytes)
+	}
+}
+
+func sendBufferReport(cmd string, id string, p *serport) {
+	bytes, err := json.Marshal(bufferReport{Cmd: cmd, Id: id, P: p.portConf.Name, QCnt: p.itemsInBuffer})
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often we look for a lost device to come back
+const reconnectPollInterval = 2 * time.Second
+
+// reconnectState is handed to the reopened port so it can continue where
+// the lost one stopped
+type reconnectState struct {
+	PrevPort string
+	LostAt   time.Time
+}
+
+type reconnectReport struct {
+	Cmd          string
+	Desc         string
+	Port         string
+	PrevPort     string
+	SerialNumber string
+	GapMs        int64
+}
+
+type pendingReconnect struct {
+	port   *serport
+	cancel chan bool
+}
+
+// Ports that are waiting for their device to come back, keyed by the
+// lowercase name of the lost port. Closing the port stops the waiting.
+var spReconnecting = struct {
+	lock  *sync.Mutex
+	ports map[string]*pendingReconnect
+}{
+	lock:  &sync.Mutex{},
+	ports: make(map[string]*pendingReconnect),
+}
+
+// spReconnect waits for the device of the lost port p to reappear and
+// reopens it with the same settings
+func spReconnect(p *serport) {
+	resume := &reconnectState{PrevPort: p.portConf.Name, LostAt: time.Now()}
+
+	key := strings.ToLower(p.portConf.Name)
+	cancel := make(chan bool)
+	pending := &pendingReconnect{p, cancel}
+	spReconnecting.lock.Lock()
+	spReconnecting.ports[key] = pending
+	spReconnecting.lock.Unlock()
+	defer func() {
+		spReconnecting.lock.Lock()
+		// the reopened port might be waiting for its device already
+		if spReconnecting.ports[key] == pending {
+			delete(spReconnecting.ports, key)
+		}
+		spReconnecting.lock.Unlock()
+	}()
+
+	sendReconnectReport("Reconnecting", "Lost the port, waiting for the device to come back.", p, resume)
+
+	ticker := time.NewTicker(reconnectPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cancel:
+			sendReconnectReport("ReconnectCancelled", "Stopped waiting for the device to come back.", p, resume)
+			return
+		case <-ticker.C:
+			portname, found := findLostDevice(p)
+			if !found {
+				continue
+			}
+			log.Printf("Found lost port %v back as %v, reopening\n", resume.PrevPort, portname)
+			conf := *p.portConf
+			conf.Name = portname
+			// stop waiting once the port is reopened, it runs on its own
+			if reopened := spStart(&conf, p.serialNumber, p.IsSecondary, resume); reopened != nil {
+				go reopened.run()
+				return
+			}
+		}
+	}
+}
+
+// spCancelReconnect stops waiting for a lost port. It returns false when
+// the port was not being reconnected.
+func spCancelReconnect(portname string) bool {
+	spReconnecting.lock.Lock()
+	defer spReconnecting.lock.Unlock()
+	pending, found := spReconnecting.ports[strings.ToLower(portname)]
+	if found {
+		close(pending.cancel)
+		delete(spReconnecting.ports, strings.ToLower(portname))
+	}
+	return found
+}
+
+// isReconnecting tells whether the given port will be reopened by a pending
+// reconnect
+func isReconnecting(portname string, serialNumber string) bool {
+	spReconnecting.lock.Lock()
+	defer spReconnecting.lock.Unlock()
+	for key, pending := range spReconnecting.ports {
+		if pending.port.serialNumber != "" {
+			if strings.EqualFold(pending.port.serialNumber, serialNumber) {
+				return true
+			}
+		} else if key == strings.ToLower(portname) {
+			return true
+		}
+	}
+	return false
+}
+
+// findLostDevice looks for the device of the lost port p. It is matched on
+// its usb serial number since the OS might give it a different name when
+// it comes back. Without a serial number only the old name is tried.
+// Network ports are always retried under their own name.
+func findLostDevice(p *serport) (string, bool) {
+	if isNetworkPort(p.portConf.Name) {
+		// there is no list to look in, just try to connect again
+		return p.portConf.Name, true
+	}
+	list, _ := GetList()
+	if p.serialNumber != "" {
+		metaports, _ := GetMetaList()
+		list = append(list, metaports...)
+	}
+	for _, item := range list {
+		if _, isOpen := findPortByName(item.Name); isOpen {
+			continue
+		}
+		if p.serialNumber != "" {
+			if strings.EqualFold(item.SerialNumber, p.serialNumber) {
+				return item.Name, true
+			}
+		} else if strings.EqualFold(item.Name, p.portConf.Name) {
+			return item.Name, true
+		}
+	}
+	return "", false
+}
+
+// lookupSerialNumber returns the usb serial number of the given port, or an
+// empty string when the OS does not tell us
+func lookupSerialNumber(portname string) string {
+	list, _ := GetList()
+	metaports, _ := GetMetaList()
+	for _, item := range append(list, metaports...) {
+		if strings.EqualFold(item.Name, portname) && item.SerialNumber != "" {
+			return item.SerialNumber
+		}
+	}
+	return ""
+}
+
+func sendReconnectReport(cmd string, desc string, p *serport, resume *reconnectState) {
+	report := reconnectReport{
+		Cmd:          cmd,
+		Desc:         desc,
+		Port:         p.portConf.Name,
+		PrevPort:     resume.PrevPort,
+		SerialNumber: p.serialNumber,
+		GapMs:        int64(time.Since(resume.LostAt) / time.Millisecond),
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
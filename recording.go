@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+type recordingReport struct {
+	Cmd     string
+	Desc    string
+	Port    string
+	LogFile string
+}
+
+// spRecord handles the record command
+//
+//	record start <port> <logfile uuid>
+//	record stop <port>
+//
+// A recording session is stored in the database so it continues when the
+// port gets reopened, until it is stopped.
+func spRecord(c *connection, args []string) {
+	if len(args) < 3 {
+		spErr(c, "You did not specify a record action and port")
+		return
+	}
+	portname := args[2]
+	switch strings.ToLower(args[1]) {
+	case "start":
+		if len(args) < 4 {
+			spErr(c, "You did not specify a log file to record to")
+			return
+		}
+		if _, err := startRecording(portname, args[3]); err != nil {
+			spErr(c, err.Error())
+		}
+	case "stop":
+		if err := stopRecording(portname); err != nil {
+			spErr(c, err.Error())
+		}
+	default:
+		spErr(c, "Unknown record action "+args[1])
+	}
+}
+
+// startRecording records the incoming lines of the port to the log file
+// from now on
+func startRecording(portname string, logFileUUID string) (models.LogFile, error) {
+	logFile := models.LogFile{}
+	if err := db.One("UUID", logFileUUID, &logFile); err != nil {
+		return logFile, errors.New("We could not find the log file " + logFileUUID)
+	}
+	recording := models.Recording{Port: portname, LogFileUUID: logFile.UUID, StartedAt: time.Now().Unix()}
+	if err := db.Save(&recording); err != nil {
+		return logFile, errors.New("Could not start recording: " + err.Error())
+	}
+	if p, isOpen := findPortByName(portname); isOpen {
+		if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok {
+			bw.FlushCorruption()
+			bw.SetLogFile(&logFile)
+			recordIdentity(bw, p.getIdentity())
+		}
+	}
+	sendRecordingReport("RecordStart", "Started recording.", portname, logFile.UUID)
+	return logFile, nil
+}
+
+// stopRecording ends the recording session of the port
+func stopRecording(portname string) error {
+	recording := models.Recording{}
+	if err := db.One("Port", portname, &recording); err != nil {
+		return errors.New("We are not recording on port " + portname)
+	}
+	if err := db.DeleteStruct(&recording); err != nil {
+		return errors.New("Could not stop recording: " + err.Error())
+	}
+	if bw, ok := findRecorder(portname); ok {
+		bw.SetLogFile(nil)
+		bw.FlushCorruption()
+	}
+	sendRecordingReport("RecordStop", "Stopped recording.", portname, recording.LogFileUUID)
+	return nil
+}
+
+// findRecorder returns the buffer flow of the open port that writes the
+// incoming lines to the log file
+func findRecorder(portname string) (*Bufferflow3Devo, bool) {
+	p, isOpen := findPortByName(portname)
+	if !isOpen {
+		return nil, false
+	}
+	bw, ok := p.bufferwatcher.(*Bufferflow3Devo)
+	return bw, ok
+}
+
+// recordingLogFile returns the log file of the recording session of a port
+// that is being opened, or nil when it is not recording. A reconnected port
+// continues the session of the lost port.
+func recordingLogFile(portname string, resume *reconnectState) *models.LogFile {
+	recording := models.Recording{}
+	if resume != nil && resume.PrevPort != portname {
+		if err := db.One("Port", resume.PrevPort, &recording); err == nil {
+			db.DeleteStruct(&recording)
+			recording.Port = portname
+			db.Save(&recording)
+		}
+	}
+	if err := db.One("Port", portname, &recording); err != nil {
+		return nil
+	}
+	logFile := &models.LogFile{}
+	if err := db.One("UUID", recording.LogFileUUID, logFile); err != nil {
+		log.Println("Log file of the recording on " + portname + " is gone, stopping the recording")
+		db.DeleteStruct(&recording)
+		return nil
+	}
+	return logFile
+}
+
+func sendRecordingReport(cmd string, desc string, portname string, logFileUUID string) {
+	report := recordingReport{
+		Cmd:     cmd,
+		Desc:    desc,
+		Port:    portname,
+		LogFile: logFileUUID,
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
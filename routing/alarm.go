@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/routing/responses"
+	"github.com/3devo/dvconnector/utils"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/julienschmidt/httprouter"
+)
+
+// swagger:route GET /alarms Alarms GetActiveAlarms
+//
+// Handler to retrieve the active alarms
+//
+// Returns the alarms that were not cleared yet, of one port when the port
+// is given, the most severe first
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:[]Alarm
+func GetActiveAlarms(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		alarms := make([]models.Alarm, 0)
+		matchers := []q.Matcher{q.Eq("ClearedAt", int64(0))}
+		if port := r.URL.Query().Get("port"); port != "" {
+			matchers = append(matchers, q.Eq("Port", port))
+		}
+		env.Db.Select(matchers...).Find(&alarms)
+		models.SortAlarms(alarms)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(alarms)
+	}
+}
+
+// swagger:route POST /alarms/{id}/acknowledge Alarms AcknowledgeAlarm
+//
+// Handler to acknowledge an alarm
+//
+// Marks the alarm as seen by an operator, it stays active until the
+// machine clears it
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+//	404: ResourceStatusResponse
+func AcknowledgeAlarm(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		id, _ := strconv.Atoi(ps.ByName("id"))
+
+		if _, err := models.AcknowledgeAlarm(env, id); err == storm.ErrNotFound {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Alarms",
+				"ACKNOWLEDGE",
+				err.Error(),
+				w)
+			return
+		} else if err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Alarms",
+				"ACKNOWLEDGE",
+				err.Error(),
+				w)
+			return
+		}
+		responses.WriteResourceStatusResponse(
+			http.StatusOK,
+			"Alarms",
+			"ACKNOWLEDGE",
+			"",
+			w)
+	}
+}
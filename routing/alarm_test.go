@@ -0,0 +1,88 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/routing"
+
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func TestAlarms(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		broadcasts := [][]byte{}
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+		env.Broadcast = func(message []byte) {
+			broadcasts = append(broadcasts, message)
+		}
+
+		alarms := []models.Alarm{
+			{Port: "COM1", Code: "Overht.0", Name: "Overht bit 0", Severity: "warning", RaisedAt: 10},
+			{Port: "COM1", Code: "FAULT.1", Name: "FAULT bit 1", Severity: "critical", RaisedAt: 20},
+			{Port: "COM1", Code: "FAULT.2", Name: "FAULT bit 2", Severity: "critical", RaisedAt: 5, ClearedAt: 8},
+			{Port: "COM2", Code: "FAULT.0", Name: "FAULT bit 0", Severity: "info", RaisedAt: 1}}
+		for i := range alarms {
+			db.Save(&alarms[i])
+		}
+
+		router := httprouter.New()
+		router.GET("/api/x/alarms", routing.GetActiveAlarms(env))
+		router.POST("/api/x/alarms/:id/acknowledge", routing.AcknowledgeAlarm(env))
+
+		Convey("Given a HTTP request for the active alarms of a port", func() {
+			req := httptest.NewRequest("GET", "/api/x/alarms?port=COM1", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should hold the alarms that were not cleared, the most severe first", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				expected, _ := json.Marshal([]models.Alarm{alarms[1], alarms[0]})
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP POST request to acknowledge an alarm", func() {
+			req := httptest.NewRequest("POST", "/api/x/alarms/1/acknowledge", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the alarm is acknowledged and the clients are told", func() {
+				alarm := models.Alarm{}
+				db.One("ID", 1, &alarm)
+				event := models.AlarmEvent{}
+				So(len(broadcasts), ShouldEqual, 1)
+				json.Unmarshal(broadcasts[0], &event)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(alarm.AcknowledgedAt, ShouldNotEqual, 0)
+				So(event.Cmd, ShouldEqual, "AlarmAcknowledged")
+				So(event.Alarm, ShouldResemble, alarm)
+			})
+		})
+
+		Convey("Given a HTTP POST request to acknowledge an unknown alarm", func() {
+			req := httptest.NewRequest("POST", "/api/x/alarms/99/acknowledge", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a http.StatusNotFound", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
@@ -0,0 +1,232 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/3devo/dvconnector/routing/responses"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// swagger:route GET /columns/ Columns GetAllColumns
+//
+// Handler to retrieve the column catalogue
+//
+// Returns all known columns
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:[]Column
+func GetAllColumns(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		columns := make([]models.Column, 0)
+		query, _ := utils.QueryBuilder(env, r)
+
+		w.WriteHeader(http.StatusOK)
+		query.Find(&columns)
+		json.NewEncoder(w).Encode(columns)
+	}
+}
+
+// swagger:route GET /columns/{name} Columns GetColumn
+//
+// Handler to retrieve a single column
+//
+// Returns a single column
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:Column
+func GetColumn(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		Column := models.Column{}
+		name := ps.ByName("name")
+
+		if err := env.Db.One("Name", name, &Column); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Columns",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Column)
+	}
+}
+
+// swagger:route POST /columns Columns CreateColumn
+//
+// Handler to create a column
+//
+// Adds a column to the catalogue, it is used from the next header a
+// device prints
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func CreateColumn(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		validation := responses.ColumnCreationBody{}
+		body, _ := ioutil.ReadAll(r.Body)
+
+		json.Unmarshal(body, &validation.Data)
+
+		if err := validateColumn(env, validation); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Columns",
+				"CREATE",
+				err.Error(),
+				w)
+			return
+		}
+		if env.Db.One("Name", validation.Data.Name, &models.Column{}) == nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Columns",
+				"CREATE",
+				fmt.Sprintf("Column %v already exists", validation.Data.Name),
+				w)
+			return
+		}
+
+		if err := env.Db.Save(&validation.Data); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Columns",
+				"CREATE",
+				err.Error(),
+				w)
+			return
+		}
+		responses.WriteResourceStatusResponse(
+			http.StatusOK,
+			"Columns",
+			"CREATE",
+			"",
+			w)
+	}
+}
+
+// swagger:route PUT /columns/{name} Columns UpdateColumn
+//
+// Handler to update a column
+//
+// Replaces the definition of an existing column, the name can not change
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func UpdateColumn(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		validation := responses.ColumnCreationBody{}
+		body, _ := ioutil.ReadAll(r.Body)
+		name := ps.ByName("name")
+
+		json.Unmarshal(body, &validation.Data)
+		validation.Data.Name = name
+
+		if err := validateColumn(env, validation); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Columns",
+				"UPDATE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.One("Name", name, &models.Column{}); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Columns",
+				"UPDATE",
+				err.Error(),
+				w)
+			return
+		}
+
+		// Save replaces the whole column, Update would keep the fields that
+		// were cleared
+		if err := env.Db.Save(&validation.Data); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusConflict,
+				"Columns",
+				"UPDATE",
+				err.Error(),
+				w)
+		} else {
+			responses.WriteResourceStatusResponse(
+				http.StatusOK,
+				"Columns",
+				"UPDATE",
+				"",
+				w)
+		}
+	}
+}
+
+// swagger:route DELETE /columns/{name} Columns DeleteColumn
+//
+// Handler to delete a column
+//
+// Removes a column from the catalogue, its values are no longer validated
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func DeleteColumn(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		Column := models.Column{}
+		name := ps.ByName("name")
+
+		if err := env.Db.One("Name", name, &Column); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Columns",
+				"DELETE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.DeleteStruct(&Column); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Columns",
+				"DELETE",
+				err.Error(),
+				w)
+			return
+		}
+		responses.WriteResourceStatusResponse(
+			http.StatusOK,
+			"Columns",
+			"DELETE",
+			"",
+			w)
+	}
+}
+
+// validateColumn validates the body, the range is only checked when both
+// ends of it are set
+func validateColumn(env *utils.Env, validation responses.ColumnCreationBody) error {
+	if err := env.Validator.Struct(validation); err != nil {
+		return err
+	}
+	column := validation.Data
+	if column.Min != nil && column.Max != nil && *column.Max < *column.Min {
+		return errors.New("the max of column " + column.Name + " is below its min")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/routing"
+
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func TestColumns(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+		env.Validator.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+			_, err := regexp.Compile(fl.Field().String())
+			return err == nil
+		})
+
+		min, max := 0.0, 450.0
+		column := models.Column{
+			Name:        "Temp5",
+			Type:        "number",
+			Unit:        "°C",
+			Description: "Temperature of heater zone 5",
+			Precision:   2,
+			Min:         &min,
+			Max:         &max}
+
+		router := httprouter.New()
+		router.POST("/api/x/columns", routing.CreateColumn(env))
+		router.GET("/api/x/columns/:name", routing.GetColumn(env))
+		router.PUT("/api/x/columns/:name", routing.UpdateColumn(env))
+
+		Convey("Given a HTTP POST request for api/x/columns with a valid body", func() {
+			requestBody, _ := json.Marshal(column)
+			req := httptest.NewRequest("POST", "/api/x/columns", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a success status and the column can be retrieved", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				req := httptest.NewRequest("GET", "/api/x/columns/Temp5", nil)
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				expected, _ := json.Marshal(column)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+
+			Convey("Then a HTTP PUT request replaces the column", func() {
+				column.Unit = "K"
+				column.Min = nil
+				requestBody, _ := json.Marshal(column)
+				req := httptest.NewRequest("PUT", "/api/x/columns/Temp5", strings.NewReader(string(requestBody)))
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				req = httptest.NewRequest("GET", "/api/x/columns/Temp5", nil)
+				resp = httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				expected, _ := json.Marshal(column)
+
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/columns with a minimum above the maximum", func() {
+			min = 500
+			requestBody, _ := json.Marshal(column)
+			req := httptest.NewRequest("POST", "/api/x/columns", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error with a range validation error", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(string(body), ShouldContainSubstring, "below its min")
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/columns with an unknown type", func() {
+			column.Type = "date"
+			requestBody, _ := json.Marshal(column)
+			req := httptest.NewRequest("POST", "/api/x/columns", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error with a type validation error", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(string(body), ShouldContainSubstring, "oneof")
+			})
+		})
+	})
+}
@@ -67,7 +67,8 @@ func UpdateConfig(env *utils.Env) httprouter.Handle {
 				w)
 			return
 		}
-		if err := env.Db.One("ID", 1, &models.Config{}); err != nil {
+		current := models.Config{}
+		if err := env.Db.One("ID", 1, &current); err != nil {
 			responses.WriteResourceStatusResponse(
 				http.StatusNotFound,
 				"Config",
@@ -76,6 +77,18 @@ func UpdateConfig(env *utils.Env) httprouter.Handle {
 				w)
 			return
 		}
+		// clients that do not send the port allowlist leave it as it is
+		if validation.Data.AllowedDevices == nil {
+			validation.Data.AllowedDevices = current.AllowedDevices
+		}
+		if validation.Data.PortPatterns == nil {
+			validation.Data.PortPatterns = current.PortPatterns
+		}
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(body, &fields)
+		if _, found := fields["showAllPorts"]; !found {
+			validation.Data.ShowAllPorts = current.ShowAllPorts
+		}
 
 		if err := env.Db.Save(&validation.Data); err != nil {
 			responses.WriteResourceStatusResponse(
@@ -0,0 +1,97 @@
+package routing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/routing"
+
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		validate := validator.New()
+		validate.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+			_, err := regexp.Compile(fl.Field().String())
+			return err == nil
+		})
+		env := &utils.Env{Db: db, Validator: validate, DataDir: path.Dir(dir)}
+		db.Save(&models.Config{
+			ID:             1,
+			AllowedDevices: []models.AllowedDevice{{Vid: "16D0", Pid: "0C5B"}},
+			PortPatterns:   []string{"ttyUSB"},
+			ShowAllPorts:   true})
+
+		router := httprouter.New()
+		router.PUT("/api/x/config", routing.UpdateConfig(env))
+
+		Convey("Given a HTTP request for /api/x/config without an allowlist", func() {
+			data := `{"id": 1, "openNetwork": true}`
+			req := httptest.NewRequest("PUT", "/api/x/config", strings.NewReader(data))
+			resp := httptest.NewRecorder()
+
+			Convey("When the request is handled by the Router", func() {
+				router.ServeHTTP(resp, req)
+
+				Convey("Then the allowlist should be left as it is", func() {
+					config := models.Config{}
+					db.One("ID", 1, &config)
+					So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+					So(config.OpenNetwork, ShouldBeTrue)
+					So(config.AllowedDevices, ShouldResemble, []models.AllowedDevice{{Vid: "16D0", Pid: "0C5B"}})
+					So(config.PortPatterns, ShouldResemble, []string{"ttyUSB"})
+					So(config.ShowAllPorts, ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("Given a HTTP request for /api/x/config with an allowlist", func() {
+			data := `{"id": 1, "allowedDevices": [{"vid": "0403", "pid": "6001", "name": "FTDI"}], "portPatterns": [], "showAllPorts": false}`
+			req := httptest.NewRequest("PUT", "/api/x/config", strings.NewReader(data))
+			resp := httptest.NewRecorder()
+
+			Convey("When the request is handled by the Router", func() {
+				router.ServeHTTP(resp, req)
+
+				Convey("Then the allowlist should be replaced", func() {
+					config := models.Config{}
+					db.One("ID", 1, &config)
+					So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+					So(config.AllowedDevices, ShouldResemble, []models.AllowedDevice{{Vid: "0403", Pid: "6001", Name: "FTDI"}})
+					So(config.PortPatterns, ShouldBeEmpty)
+					So(config.ShowAllPorts, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Given a HTTP request for /api/x/config with an invalid allowlist", func() {
+			data := `{"id": 1, "allowedDevices": [{"vid": "xyz", "pid": "6001"}], "portPatterns": ["("]}`
+			req := httptest.NewRequest("PUT", "/api/x/config", strings.NewReader(data))
+			resp := httptest.NewRecorder()
+
+			Convey("When the request is handled by the Router", func() {
+				router.ServeHTTP(resp, req)
+
+				Convey("Then the response should be a http.StatusInternalServerError and the allowlist unchanged", func() {
+					config := models.Config{}
+					db.One("ID", 1, &config)
+					So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+					So(config.AllowedDevices, ShouldResemble, []models.AllowedDevice{{Vid: "16D0", Pid: "0C5B"}})
+				})
+			})
+		})
+	})
+}
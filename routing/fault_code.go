@@ -0,0 +1,225 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/3devo/dvconnector/routing/responses"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// swagger:route GET /faultCodes/ FaultCodes GetAllFaultCodes
+//
+// Handler to retrieve the fault catalogue
+//
+// Returns all named fault bits
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:[]FaultCode
+func GetAllFaultCodes(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		faultCodes := make([]models.FaultCode, 0)
+		query, _ := utils.QueryBuilder(env, r)
+
+		w.WriteHeader(http.StatusOK)
+		query.Find(&faultCodes)
+		json.NewEncoder(w).Encode(faultCodes)
+	}
+}
+
+// swagger:route GET /faultCodes/{code} FaultCodes GetFaultCode
+//
+// Handler to retrieve a single fault code
+//
+// Returns a single fault code
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:FaultCode
+func GetFaultCode(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		FaultCode := models.FaultCode{}
+		code := ps.ByName("code")
+
+		if err := env.Db.One("Code", code, &FaultCode); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"FaultCodes",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(FaultCode)
+	}
+}
+
+// swagger:route POST /faultCodes FaultCodes CreateFaultCode
+//
+// Handler to create a fault code
+//
+// Names a bit of the FAULT or Overht column, the code is set from the
+// column and the bit
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func CreateFaultCode(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		validation := responses.FaultCodeCreationBody{}
+		body, _ := ioutil.ReadAll(r.Body)
+
+		json.Unmarshal(body, &validation.Data)
+
+		if err := env.Validator.Struct(validation); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"FaultCodes",
+				"CREATE",
+				err.Error(),
+				w)
+			return
+		}
+		validation.Data.Code = models.FaultCodeOf(validation.Data.Column, validation.Data.Bit)
+		if env.Db.One("Code", validation.Data.Code, &models.FaultCode{}) == nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"FaultCodes",
+				"CREATE",
+				fmt.Sprintf("Fault code %v already exists", validation.Data.Code),
+				w)
+			return
+		}
+
+		if err := env.Db.Save(&validation.Data); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"FaultCodes",
+				"CREATE",
+				err.Error(),
+				w)
+			return
+		}
+		responses.WriteResourceStatusResponse(
+			http.StatusOK,
+			"FaultCodes",
+			"CREATE",
+			"",
+			w)
+	}
+}
+
+// swagger:route PUT /faultCodes/{code} FaultCodes UpdateFaultCode
+//
+// Handler to update a fault code
+//
+// Replaces the name, severity and description of a fault code, the column
+// and the bit can not change
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func UpdateFaultCode(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		validation := responses.FaultCodeCreationBody{}
+		body, _ := ioutil.ReadAll(r.Body)
+		code := ps.ByName("code")
+
+		json.Unmarshal(body, &validation.Data)
+		validation.Data.Code = code
+
+		err := env.Validator.Struct(validation)
+		if err == nil && models.FaultCodeOf(validation.Data.Column, validation.Data.Bit) != code {
+			err = errors.New("the column and bit do not match fault code " + code)
+		}
+		if err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"FaultCodes",
+				"UPDATE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.One("Code", code, &models.FaultCode{}); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"FaultCodes",
+				"UPDATE",
+				err.Error(),
+				w)
+			return
+		}
+
+		// Save replaces the whole fault code, Update would keep the fields
+		// that were cleared
+		if err := env.Db.Save(&validation.Data); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusConflict,
+				"FaultCodes",
+				"UPDATE",
+				err.Error(),
+				w)
+		} else {
+			responses.WriteResourceStatusResponse(
+				http.StatusOK,
+				"FaultCodes",
+				"UPDATE",
+				"",
+				w)
+		}
+	}
+}
+
+// swagger:route DELETE /faultCodes/{code} FaultCodes DeleteFaultCode
+//
+// Handler to delete a fault code
+//
+// Removes a fault code from the catalogue, its bit raises a generic alarm
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func DeleteFaultCode(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		FaultCode := models.FaultCode{}
+		code := ps.ByName("code")
+
+		if err := env.Db.One("Code", code, &FaultCode); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"FaultCodes",
+				"DELETE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.DeleteStruct(&FaultCode); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"FaultCodes",
+				"DELETE",
+				err.Error(),
+				w)
+			return
+		}
+		responses.WriteResourceStatusResponse(
+			http.StatusOK,
+			"FaultCodes",
+			"DELETE",
+			"",
+			w)
+	}
+}
@@ -0,0 +1,88 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/routing"
+
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func TestFaultCodes(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+
+		faultCode := models.FaultCode{
+			Column:   "FAULT",
+			Bit:      3,
+			Name:     "Motor stalled",
+			Severity: "critical"}
+
+		router := httprouter.New()
+		router.POST("/api/x/faultCodes", routing.CreateFaultCode(env))
+		router.GET("/api/x/faultCodes/:code", routing.GetFaultCode(env))
+		router.PUT("/api/x/faultCodes/:code", routing.UpdateFaultCode(env))
+
+		Convey("Given a HTTP POST request for api/x/faultCodes with a valid body", func() {
+			requestBody, _ := json.Marshal(faultCode)
+			req := httptest.NewRequest("POST", "/api/x/faultCodes", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the fault code can be retrieved by its column and bit", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				req := httptest.NewRequest("GET", "/api/x/faultCodes/FAULT.3", nil)
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				faultCode.Code = "FAULT.3"
+				expected, _ := json.Marshal(faultCode)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+
+			Convey("Then a HTTP PUT request can not move the fault code to another bit", func() {
+				faultCode.Bit = 4
+				requestBody, _ := json.Marshal(faultCode)
+				req := httptest.NewRequest("PUT", "/api/x/faultCodes/FAULT.3", strings.NewReader(string(requestBody)))
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/faultCodes with an unknown severity", func() {
+			faultCode.Severity = "fatal"
+			requestBody, _ := json.Marshal(faultCode)
+			req := httptest.NewRequest("POST", "/api/x/faultCodes", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error with a severity validation error", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(string(body), ShouldContainSubstring, "Severity")
+			})
+		})
+	})
+}
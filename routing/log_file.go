@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/3devo/dvconnector/models"
 	"github.com/3devo/dvconnector/routing/responses"
@@ -71,6 +74,174 @@ func GetLogFile(env *utils.Env) httprouter.Handle {
 	}
 }
 
+// swagger:route GET /logFiles/{uuid}/corruption logFiles GetLogFileCorruption
+//
+// Handler to retrieve the corruption statistics of a logFile
+//
+// This will return the counts of the corrupt lines that came in while
+// recording to the log
+//
+// Produces:
+//	application/json
+//
+// Responses:
+// 	200: body:CorruptionStats
+//	404: ResourceStatusResponse
+func GetLogFileCorruption(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		logFile := models.LogFile{}
+		uuid := ps.ByName("uuid")
+
+		if err := env.Db.One("UUID", uuid, &logFile); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Logfiles",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		// a log without corrupt lines has no statistics yet
+		stats := models.CorruptionStats{LogFileUUID: logFile.UUID}
+		env.Db.One("LogFileUUID", logFile.UUID, &stats)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// swagger:route GET /logFiles/{uuid}/anomalies logFiles GetLogFileAnomalies
+//
+// Handler to retrieve the anomalies of a logFile
+//
+// This will return the periods in which implausible values came in while
+// recording to the log
+//
+// Produces:
+//	application/json
+//
+// Responses:
+// 	200: body:[]Anomaly
+//	404: ResourceStatusResponse
+func GetLogFileAnomalies(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		logFile := models.LogFile{}
+		uuid := ps.ByName("uuid")
+
+		if err := env.Db.One("UUID", uuid, &logFile); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Logfiles",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		anomalies := make([]models.Anomaly, 0)
+		env.Db.Find("LogFileUUID", logFile.UUID, &anomalies)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(anomalies)
+	}
+}
+
+// swagger:route GET /logFiles/{uuid}/timeline logFiles GetLogFileTimeline
+//
+// Handler to retrieve the Status timeline of a logFile
+//
+// This will return the Status transitions recorded to the log and the time
+// spent in every Status
+//
+// Produces:
+//	application/json
+//
+// Responses:
+// 	200: TimelineResponse
+//	404: ResourceStatusResponse
+func GetLogFileTimeline(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		logFile := models.LogFile{}
+		uuid := ps.ByName("uuid")
+
+		if err := env.Db.One("UUID", uuid, &logFile); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Logfiles",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		timeline := responses.TimelineResponse{
+			Transitions: make([]models.StatusTransition, 0),
+			Durations:   make(map[string]float64),
+		}
+		env.Db.Find("LogFileUUID", logFile.UUID, &timeline.Transitions)
+		for _, transition := range timeline.Transitions {
+			if transition.From != "" {
+				timeline.Durations[transition.From] += transition.Seconds
+			}
+		}
+		if count := len(timeline.Transitions); count > 0 {
+			last := timeline.Transitions[count-1]
+			timeline.Durations[last.To] += secondsUntilLastLine(last, &logFile, env)
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(timeline)
+	}
+}
+
+// secondsUntilLastLine returns the seconds spent in the Status of the last
+// transition up to the last line of the log. They are taken from the Time
+// column, or from the clock when the device rebooted in between.
+func secondsUntilLastLine(transition models.StatusTransition, logFile *models.LogFile, env *utils.Env) float64 {
+	line, writtenAt, err := logFile.LastLine(env)
+	if err != nil {
+		return 0
+	}
+	start, startErr := strconv.ParseFloat(transition.Time, 64)
+	end, endErr := strconv.ParseFloat(strings.SplitN(line, "\t", 2)[0], 64)
+	if startErr == nil && endErr == nil && end >= start {
+		return end - start
+	}
+	if transition.Timestamp > 0 && writtenAt.Unix() >= transition.Timestamp {
+		return writtenAt.Sub(time.Unix(transition.Timestamp, 0)).Seconds()
+	}
+	return 0
+}
+
+// swagger:route GET /logFiles/{uuid}/alarms logFiles GetLogFileAlarms
+//
+// Handler to retrieve the alarm history of a logFile
+//
+// This will return the alarms raised while recording to the log, in the
+// order they were raised
+//
+// Produces:
+//	application/json
+//
+// Responses:
+// 	200: body:[]Alarm
+//	404: ResourceStatusResponse
+func GetLogFileAlarms(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		logFile := models.LogFile{}
+		uuid := ps.ByName("uuid")
+
+		if err := env.Db.One("UUID", uuid, &logFile); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Logfiles",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		alarms := make([]models.Alarm, 0)
+		env.Db.Find("LogFileUUID", logFile.UUID, &alarms)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(alarms)
+	}
+}
+
 //swagger:route POST /logFiles logFiles CreateLogFile
 //
 // Handler to create a new log file
@@ -8,9 +8,11 @@ import (
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/3devo/dvconnector/models"
 	"github.com/3devo/dvconnector/routing"
 	"github.com/3devo/dvconnector/routing/responses"
 	"github.com/google/uuid"
@@ -234,6 +236,192 @@ func TestGetMultipleLogFile(t *testing.T) {
 	})
 }
 
+func TestGetLogFileCorruption(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+		stats := models.CorruptionStats{
+			LogFileUUID:  "550e8400-e29b-41d4-a716-446655440001",
+			DroppedLines: 2,
+			DroppedBytes: 40,
+			LongestBurst: 2}
+		db.Save(&stats)
+
+		Convey("Given a HTTP request for the corruption of a log with corrupt lines", func() {
+			router := httprouter.New()
+			router.GET("/api/x/logFiles/:uuid/corruption", routing.GetLogFileCorruption(env))
+
+			req := httptest.NewRequest("GET", "/api/x/logFiles/550e8400-e29b-41d4-a716-446655440001/corruption", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should return http.StatusOK with the statistics", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				expected, _ := json.Marshal(stats)
+
+				So(result.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the corruption of a log without corrupt lines", func() {
+			router := httprouter.New()
+			router.GET("/api/x/logFiles/:uuid/corruption", routing.GetLogFileCorruption(env))
+
+			req := httptest.NewRequest("GET", "/api/x/logFiles/550e8400-e29b-41d4-a716-446655440000/corruption", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should return http.StatusOK with empty statistics", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				expected, _ := json.Marshal(models.CorruptionStats{LogFileUUID: "550e8400-e29b-41d4-a716-446655440000"})
+
+				So(result.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the corruption of an unknown log", func() {
+			router := httprouter.New()
+			router.GET("/api/x/logFiles/:uuid/corruption", routing.GetLogFileCorruption(env))
+
+			req := httptest.NewRequest("GET", "/api/x/logFiles/undefined/corruption", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should be a http.StatusNotFound", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestGetLogFileAnomalies(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+		anomaly := models.Anomaly{
+			LogFileUUID: "550e8400-e29b-41d4-a716-446655440001",
+			Column:      "Temp2",
+			Rule:        "max",
+			Limit:       450,
+			Worst:       900,
+			Start:       "120",
+			End:         "121",
+			Samples:     2}
+		db.Save(&anomaly)
+		db.Save(&models.Anomaly{LogFileUUID: "550e8400-e29b-41d4-a716-446655440002", Column: "Temp1", Rule: "min"})
+
+		router := httprouter.New()
+		router.GET("/api/x/logFiles/:uuid/anomalies", routing.GetLogFileAnomalies(env))
+
+		Convey("Given a HTTP request for the anomalies of a log", func() {
+			req := httptest.NewRequest("GET", "/api/x/logFiles/550e8400-e29b-41d4-a716-446655440001/anomalies", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should return http.StatusOK with the anomalies of that log only", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				expected, _ := json.Marshal([]models.Anomaly{anomaly})
+
+				So(result.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the anomalies of a log without anomalies", func() {
+			req := httptest.NewRequest("GET", "/api/x/logFiles/550e8400-e29b-41d4-a716-446655440000/anomalies", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should return http.StatusOK with an empty list", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldEqual, "[]\n")
+			})
+		})
+
+		Convey("Given a HTTP request for the anomalies of an unknown log", func() {
+			req := httptest.NewRequest("GET", "/api/x/logFiles/undefined/anomalies", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should be a http.StatusNotFound", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestGetLogFileTimeline(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+		transitions := []models.StatusTransition{
+			{LogFileUUID: "550e8400-e29b-41d4-a716-446655440001", To: "Idle", Time: "10"},
+			{LogFileUUID: "550e8400-e29b-41d4-a716-446655440001", From: "Idle", To: "Heating", Time: "70", Seconds: 60},
+			{LogFileUUID: "550e8400-e29b-41d4-a716-446655440001", From: "Heating", To: "Extruding", Time: "670", Seconds: 600},
+			{LogFileUUID: "550e8400-e29b-41d4-a716-446655440001", From: "Extruding", To: "Idle", Time: "3670", Seconds: 3000},
+			{LogFileUUID: "550e8400-e29b-41d4-a716-446655440001", From: "Idle", To: "Heating", Time: "3700", Seconds: 30}}
+		for i := range transitions {
+			db.Save(&transitions[i])
+		}
+
+		router := httprouter.New()
+		router.GET("/api/x/logFiles/:uuid/timeline", routing.GetLogFileTimeline(env))
+
+		Convey("Given a HTTP request for the timeline of a log", func() {
+			req := httptest.NewRequest("GET", "/api/x/logFiles/550e8400-e29b-41d4-a716-446655440001/timeline", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should return http.StatusOK with the transitions and the time spent in every status", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				expected, _ := json.Marshal(responses.TimelineResponse{
+					Transitions: transitions,
+					Durations:   map[string]float64{"Idle": 90, "Heating": 600, "Extruding": 3000}})
+
+				So(result.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the timeline of a log with lines after the last transition", func() {
+			env.DataDir = dir
+			logFile := models.LogFile{}
+			db.One("UUID", "550e8400-e29b-41d4-a716-446655440001", &logFile)
+			ioutil.WriteFile(filepath.Join(dir, "logs", logFile.GetFileName()), []byte("3700\tHeating\n3760\tHeating\n"), os.ModePerm)
+			defer os.Remove(filepath.Join(dir, "logs", logFile.GetFileName()))
+			req := httptest.NewRequest("GET", "/api/x/logFiles/550e8400-e29b-41d4-a716-446655440001/timeline", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the last status should count up to the last line", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				expected, _ := json.Marshal(responses.TimelineResponse{
+					Transitions: transitions,
+					Durations:   map[string]float64{"Idle": 90, "Heating": 660, "Extruding": 3000}})
+
+				So(result.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the timeline of an unknown log", func() {
+			req := httptest.NewRequest("GET", "/api/x/logFiles/undefined/timeline", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			Convey("Then the response should be a http.StatusNotFound", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
 func TestCreateLogFile(t *testing.T) {
 	Convey("Setup", t, func() {
 		dir, db := PrepareDb()
@@ -0,0 +1,73 @@
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/3devo/dvconnector/routing/responses"
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// swagger:route GET /ports Ports GetPorts
+//
+// # Handler to retrieve the serial ports
+//
+// # Returns the serial ports like the list command of the websocket does
+//
+// Produces:
+//
+//	application/json
+//
+// Responses:
+//
+//	200: body:[]SpPortItem
+func GetPorts(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var ports interface{} = []interface{}{}
+		if env.Ports != nil {
+			ports = env.Ports()
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ports)
+	}
+}
+
+// swagger:route GET /ports/{name}/snapshot Ports GetPortSnapshot
+//
+// # Handler to retrieve the live state of a port
+//
+// Returns the header, the last data line, the Status and whether the port
+// is open and recording. The device path can be left out of unix port
+// names, i.e. ttyACM0 for /dev/ttyACM0
+//
+// Produces:
+//
+//	application/json
+//
+// Responses:
+//
+//	200: body:portSnapshot
+//	404: ResourceStatusResponse
+func GetPortSnapshot(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var snapshot interface{}
+		found := false
+		if env.PortSnapshot != nil {
+			snapshot, found = env.PortSnapshot(ps.ByName("name"))
+		}
+		if !found {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Ports",
+				"GET",
+				"port "+ps.ByName("name")+" not found",
+				w)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
@@ -0,0 +1,95 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/3devo/dvconnector/routing"
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPorts(t *testing.T) {
+	Convey("Setup", t, func() {
+		ports := []map[string]interface{}{
+			{"Name": "/dev/ttyACM0", "IsOpen": true},
+			{"Name": "COM3", "IsOpen": false}}
+		snapshot := map[string]interface{}{
+			"Name":   "/dev/ttyACM0",
+			"IsOpen": true,
+			"Status": "Extruding",
+			"Values": map[string]interface{}{"Time": 12.0, "Status": "Extruding"}}
+		env := &utils.Env{}
+		env.Ports = func() interface{} {
+			return ports
+		}
+		env.PortSnapshot = func(name string) (interface{}, bool) {
+			if strings.EqualFold(name, "ttyACM0") {
+				return snapshot, true
+			}
+			return nil, false
+		}
+
+		router := httprouter.New()
+		router.GET("/api/x/ports", routing.GetPorts(env))
+		router.GET("/api/x/ports/:name/snapshot", routing.GetPortSnapshot(env))
+
+		Convey("Given a HTTP request for the ports", func() {
+			req := httptest.NewRequest("GET", "/api/x/ports", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should hold the port list", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				expected, _ := json.Marshal(ports)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the snapshot of a port", func() {
+			req := httptest.NewRequest("GET", "/api/x/ports/ttyACM0/snapshot", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should hold the snapshot", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				expected, _ := json.Marshal(snapshot)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP request for the snapshot of an unknown port", func() {
+			req := httptest.NewRequest("GET", "/api/x/ports/COM9/snapshot", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the port is not found", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("Given a HTTP request for the ports without a serial hub", func() {
+			router := httprouter.New()
+			router.GET("/api/x/ports", routing.GetPorts(&utils.Env{}))
+			req := httptest.NewRequest("GET", "/api/x/ports", nil)
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should hold no ports", func() {
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldEqual, "[]\n")
+			})
+		})
+	})
+}
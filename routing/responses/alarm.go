@@ -0,0 +1,29 @@
+package responses
+
+import "github.com/3devo/dvconnector/models"
+
+// FaultCodeCreationBody is the body needed to create a fault code through
+// rest
+// swagger:parameters CreateFaultCode UpdateFaultCode
+type FaultCodeCreationBody struct {
+	// in:body
+	Data models.FaultCode `json:"data"`
+}
+
+// swagger:parameters GetFaultCode UpdateFaultCode DeleteFaultCode
+type CodePathParam struct {
+	// in: path
+	Code string `json:"code"`
+}
+
+// swagger:parameters AcknowledgeAlarm
+type IdPathParam struct {
+	// in: path
+	ID int `json:"id"`
+}
+
+// swagger:parameters GetActiveAlarms
+type PortQueryParam struct {
+	// in: query
+	Port string `json:"port"`
+}
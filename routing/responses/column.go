@@ -0,0 +1,16 @@
+package responses
+
+import "github.com/3devo/dvconnector/models"
+
+// ColumnCreationBody is the body needed to create a column through rest
+// swagger:parameters CreateColumn UpdateColumn
+type ColumnCreationBody struct {
+	// in:body
+	Data models.Column `json:"data"`
+}
+
+// swagger:parameters GetColumn UpdateColumn DeleteColumn GetPortSnapshot
+type NamePathParam struct {
+	// in: path
+	Name string `json:"name"`
+}
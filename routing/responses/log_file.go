@@ -19,6 +19,19 @@ type LogFileResponse struct {
 	Timestamp int64  `json:"timestamp"`
 	Note      string `json:"note"`
 	Log       string `json:"log"`
+	// Filament Maker the log was recorded from
+	Firmware      string `json:"firmware,omitempty"`
+	Hardware      string `json:"hardware,omitempty"`
+	MachineSerial string `json:"machineSerial,omitempty"`
+}
+
+// TimelineResponse is the Status timeline of a logFile
+//
+// swagger:response TimelineResponse
+type TimelineResponse struct {
+	Transitions []models.StatusTransition `json:"transitions"`
+	// seconds spent in every Status up to the last line of the log
+	Durations map[string]float64 `json:"durations"`
 }
 
 // LogFileCreationBody is a model for creating logfiles through rest
@@ -40,6 +53,9 @@ func GenerateLogResponse(logFile *models.LogFile, env *utils.Env) *LogFileRespon
 	response.Name = logFile.Name
 	response.Timestamp = logFile.Timestamp
 	response.UUID = logFile.UUID
+	response.Firmware = logFile.Firmware
+	response.Hardware = logFile.Hardware
+	response.MachineSerial = logFile.MachineSerial
 
 	logData, err := ioutil.ReadFile(filepath.Join(env.DataDir, "logs", logFile.GetFileName())) // just pass the file name
 	if err == nil {
@@ -0,0 +1,10 @@
+package responses
+
+import "github.com/3devo/dvconnector/models"
+
+// SequenceCreationBody is the body needed to create a sequence through rest
+// swagger:parameters CreateSequence UpdateSequence
+type SequenceCreationBody struct {
+	// in:body
+	Data models.Sequence `json:"data"`
+}
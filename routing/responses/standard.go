@@ -19,7 +19,7 @@ type ResourceStatusResponse struct {
 	} `json:"body"`
 }
 
-//swagger:parameters GetLogFile UpdateLogFile DeleteLogFile GetChart UpdateChart DeleteChart GetSheet UpdateSheet DeleteSheet GetWorkspace UpdateWorkspace DeleteWorkspace
+//swagger:parameters GetLogFile GetLogFileCorruption GetLogFileAnomalies GetLogFileTimeline GetLogFileAlarms UpdateLogFile DeleteLogFile GetChart UpdateChart DeleteChart GetSheet UpdateSheet DeleteSheet GetWorkspace UpdateWorkspace DeleteWorkspace GetSequence UpdateSequence DeleteSequence
 type UidPathParam struct {
 	// in: path
 	UUID string `json:"uuid"`
@@ -0,0 +1,218 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/3devo/dvconnector/routing/responses"
+	"github.com/tidwall/gjson"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// swagger:route GET /sequences/ Sequences GetAllSequences
+//
+// Handler to retrieve all available sequences
+//
+// Returns all sequences
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:[]Sequence
+func GetAllSequences(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		sequences := make([]models.Sequence, 0)
+		query, _ := utils.QueryBuilder(env, r)
+
+		w.WriteHeader(http.StatusOK)
+		query.Find(&sequences)
+		json.NewEncoder(w).Encode(sequences)
+	}
+}
+
+// swagger:route GET /sequences/{uuid} Sequences GetSequence
+//
+// Handler to retrieve a single sequence
+//
+// Returns a single sequence
+//
+// Produces:
+// 	application/json
+// Responses:
+//	200: body:Sequence
+func GetSequence(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		Sequence := models.Sequence{}
+		uuid := ps.ByName("uuid")
+
+		if err := env.Db.One("UUID", uuid, &Sequence); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Sequences",
+				"GET",
+				err.Error(),
+				w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Sequence)
+	}
+}
+
+// swagger:route POST /sequences Sequences CreateSequence
+//
+// Handler to create a sequence
+//
+// Creates a new sequence
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func CreateSequence(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		validation := responses.SequenceCreationBody{}
+		body, _ := ioutil.ReadAll(r.Body)
+		data := gjson.Parse(string(body))
+
+		json.Unmarshal(body, &validation.Data)
+
+		if err := env.Validator.Struct(validation); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Sequences",
+				"CREATE",
+				err.Error(),
+				w)
+			return
+		}
+		if env.Db.One("UUID", data.Get("uuid").String(), &models.Sequence{}) == nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Sequences",
+				"CREATE",
+				fmt.Sprintf("Sequence with %v already exists", data.Get("uuid").String()),
+				w)
+			return
+		}
+
+		if err := env.Db.Save(&validation.Data); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Sequences",
+				"CREATE",
+				err.Error(),
+				w)
+			return
+		} else {
+			responses.WriteResourceStatusResponse(
+				http.StatusOK,
+				"Sequences",
+				"CREATE",
+				"",
+				w)
+		}
+	}
+}
+
+// swagger:route PUT /sequences/{uuid} Sequences UpdateSequence
+//
+// Handler to update a sequence
+//
+// Replaces an existing sequence with new values
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func UpdateSequence(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		validation := responses.SequenceCreationBody{}
+		body, _ := ioutil.ReadAll(r.Body)
+		data := gjson.Parse(string(body))
+
+		json.Unmarshal(body, &validation.Data)
+
+		if err := env.Validator.Struct(validation); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Sequences",
+				"UPDATE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.One("UUID", data.Get("uuid").String(), &models.Sequence{}); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Sequences",
+				"UPDATE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.Update(&validation.Data); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusConflict,
+				"Sequences",
+				"UPDATE",
+				err.Error(),
+				w)
+		} else {
+			responses.WriteResourceStatusResponse(
+				http.StatusOK,
+				"Sequences",
+				"UPDATE",
+				"",
+				w)
+		}
+	}
+}
+
+// swagger:route DELETE /sequences/{uuid} Sequences DeleteSequence
+//
+// Handler to delete a sequence
+//
+// Deletes a existing sequence
+// Produces:
+// 	application/json
+// Responses:
+//	200: ResourceStatusResponse
+func DeleteSequence(env *utils.Env) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		Sequence := models.Sequence{}
+		uuid := ps.ByName("uuid")
+
+		if err := env.Db.One("UUID", uuid, &Sequence); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusNotFound,
+				"Sequences",
+				"DELETE",
+				err.Error(),
+				w)
+			return
+		}
+
+		if err := env.Db.DeleteStruct(&Sequence); err != nil {
+			responses.WriteResourceStatusResponse(
+				http.StatusInternalServerError,
+				"Sequences",
+				"DELETE",
+				err.Error(),
+				w)
+			return
+		}
+		responses.WriteResourceStatusResponse(
+			http.StatusOK,
+			"Sequences",
+			"DELETE",
+			"",
+			w)
+
+	}
+}
@@ -0,0 +1,159 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/3devo/dvconnector/routing"
+	"github.com/3devo/dvconnector/routing/responses"
+
+	"github.com/3devo/dvconnector/utils"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func TestCreateSequence(t *testing.T) {
+	Convey("Setup", t, func() {
+		dir, db := PrepareDb()
+		defer os.RemoveAll(dir)
+		defer db.Close()
+		env := &utils.Env{Db: db, Validator: validator.New(), DataDir: path.Dir(dir)}
+		env.Validator.RegisterValidation("uuid", func(fl validator.FieldLevel) bool {
+			return utils.IsValidUUID(fl.Field().String())
+		})
+		env.Validator.RegisterValidation("sequence-condition", func(fl validator.FieldLevel) bool {
+			return strings.Contains(fl.Field().String(), ">=")
+		})
+		env.Validator.RegisterValidation("required_if", utils.RequiredIf)
+
+		sequence := models.Sequence{
+			UUID:  "550e8400-e29b-41d4-a716-446655440000",
+			Title: "heat up",
+			Steps: []models.SequenceStep{
+				{Type: "send", Command: "SetT1 200"},
+				{Type: "waitFor", Condition: "Temp1 >= SetT1 - 2", Seconds: 60},
+				{Type: "branch", Branches: map[string]int{"Fault": 3}},
+				{Type: "wait", Seconds: 5}}}
+
+		router := httprouter.New()
+		router.POST("/api/x/sequences", routing.CreateSequence(env))
+		router.GET("/api/x/sequences/:uuid", routing.GetSequence(env))
+
+		Convey("Given a HTTP POST request for api/x/sequences with a valid body", func() {
+			requestBody, _ := json.Marshal(sequence)
+			req := httptest.NewRequest("POST", "/api/x/sequences", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a success status and the sequence can be retrieved", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				req := httptest.NewRequest("GET", "/api/x/sequences/"+sequence.UUID, nil)
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+				body, _ := ioutil.ReadAll(resp.Result().Body)
+				expected, _ := json.Marshal(sequence)
+
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/sequences with an unknown step type", func() {
+			sequence.Steps[0].Type = "jump"
+			requestBody, _ := json.Marshal(sequence)
+			req := httptest.NewRequest("POST", "/api/x/sequences", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error with step type validation fail error", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				response := responses.ResourceStatusResponse{}
+				response.Body.Code = http.StatusInternalServerError
+				response.Body.Resource = "Sequences"
+				response.Body.Action = "CREATE"
+				response.Body.Error = "Key: 'SequenceCreationBody.Data.Steps[0].Type' Error:Field validation for 'Type' failed on the 'oneof' tag"
+				expected, _ := json.Marshal(response.Body)
+
+				So(result.StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/sequences with a waitFor step without a condition", func() {
+			sequence.Steps[1].Condition = ""
+			requestBody, _ := json.Marshal(sequence)
+			req := httptest.NewRequest("POST", "/api/x/sequences", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error with condition validation fail error", func() {
+				result := resp.Result()
+				body, _ := ioutil.ReadAll(result.Body)
+				response := responses.ResourceStatusResponse{}
+				response.Body.Code = http.StatusInternalServerError
+				response.Body.Resource = "Sequences"
+				response.Body.Action = "CREATE"
+				response.Body.Error = "Key: 'SequenceCreationBody.Data.Steps[1].Condition' Error:Field validation for 'Condition' failed on the 'required_if' tag"
+				expected, _ := json.Marshal(response.Body)
+
+				So(result.StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(string(body), ShouldResemble, string(append(expected, 10)))
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/sequences with a send step without a command", func() {
+			sequence.Steps[0].Command = ""
+			requestBody, _ := json.Marshal(sequence)
+			req := httptest.NewRequest("POST", "/api/x/sequences", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(db.One("UUID", sequence.UUID, &models.Sequence{}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/sequences with a wait step without seconds", func() {
+			sequence.Steps[3].Seconds = 0
+			requestBody, _ := json.Marshal(sequence)
+			req := httptest.NewRequest("POST", "/api/x/sequences", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(db.One("UUID", sequence.UUID, &models.Sequence{}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given a HTTP POST request for api/x/sequences with an invalid condition", func() {
+			sequence.Steps[1].Condition = "Temp1 is hot"
+			requestBody, _ := json.Marshal(sequence)
+			req := httptest.NewRequest("POST", "/api/x/sequences", strings.NewReader(string(requestBody)))
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			Convey("Then the response should be a internal server error", func() {
+				So(resp.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(db.One("UUID", sequence.UUID, &models.Sequence{}), ShouldNotBeNil)
+			})
+		})
+	})
+}
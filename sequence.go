@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+// A sequence runs the steps of a stored models.Sequence on a port, i.e. to
+// send the setpoints of a run and wait for the zones to heat up. One
+// sequence can run on a port at a time. The steps are evaluated every
+// sequenceTick, time spent paused does not count for waits and timeouts.
+
+const sequenceTick = 250 * time.Millisecond
+
+var reSequenceCondition = regexp.MustCompile(`^(.+?)(>=|<=|==|!=|>|<)(.+)$`)
+var reSequenceTerm = regexp.MustCompile(`^\s*([+-]?)\s*([A-Za-z][A-Za-z0-9]*|[0-9]+(\.[0-9]+)?)\s*`)
+
+// sequenceTerm is a column or a number in a condition
+type sequenceTerm struct {
+	negative bool
+	column   string
+	value    float64
+}
+
+// sequenceCondition compares two sums of terms, i.e. Temp1 >= SetT1 - 2
+type sequenceCondition struct {
+	left  []sequenceTerm
+	op    string
+	right []sequenceTerm
+}
+
+// parseSequenceCondition parses a condition, the columns in it must be
+// known numeric columns
+func parseSequenceCondition(condition string) (*sequenceCondition, error) {
+	match := reSequenceCondition.FindStringSubmatch(condition)
+	if match == nil {
+		return nil, errors.New("condition " + condition + " has no comparison")
+	}
+	left, err := parseSequenceTerms(match[1])
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseSequenceTerms(match[3])
+	if err != nil {
+		return nil, err
+	}
+	return &sequenceCondition{left: left, op: match[2], right: right}, nil
+}
+
+func parseSequenceTerms(expression string) ([]sequenceTerm, error) {
+	terms := []sequenceTerm{}
+	rest := expression
+	for strings.TrimSpace(rest) != "" {
+		match := reSequenceTerm.FindStringSubmatch(rest)
+		if match == nil || (len(terms) > 0 && match[1] == "") {
+			return nil, errors.New("can not understand " + strings.TrimSpace(expression))
+		}
+		term := sequenceTerm{negative: match[1] == "-"}
+		if value, err := strconv.ParseFloat(match[2], 64); err == nil {
+			term.value = value
+		} else if !isNumericColumn(match[2]) {
+			return nil, errors.New("unknown numeric column " + match[2])
+		} else {
+			term.column = match[2]
+		}
+		terms = append(terms, term)
+		rest = rest[len(match[0]):]
+	}
+	if len(terms) == 0 {
+		return nil, errors.New("missing value in " + expression)
+	}
+	return terms, nil
+}
+
+// isNumericColumn tells whether the column is known and holds numbers
+func isNumericColumn(name string) bool {
+	column, known := knownColumns()[name]
+	return known && column.Type == "number"
+}
+
+func sumSequenceTerms(terms []sequenceTerm, values map[string]string) (float64, bool) {
+	sum := 0.0
+	for _, term := range terms {
+		value := term.value
+		if term.column != "" {
+			var err error
+			if value, err = strconv.ParseFloat(values[term.column], 64); err != nil {
+				return 0, false
+			}
+		}
+		if term.negative {
+			value = -value
+		}
+		sum += value
+	}
+	return sum, true
+}
+
+// holds tells whether the condition holds for the values, it does not when
+// a column has no value
+func (c *sequenceCondition) holds(values map[string]string) bool {
+	left, ok := sumSequenceTerms(c.left, values)
+	if !ok {
+		return false
+	}
+	right, ok := sumSequenceTerms(c.right, values)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case ">=":
+		return left >= right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case "<":
+		return left < right
+	case "==":
+		return left == right
+	}
+	return left != right
+}
+
+type sequenceReport struct {
+	Cmd      string
+	Desc     string
+	Port     string
+	Sequence string
+	Title    string
+	Step     int
+	Type     string `json:",omitempty"`
+}
+
+// sequenceRun is a sequence running on a port
+type sequenceRun struct {
+	portname   string
+	sequence   models.Sequence
+	conditions map[int]*sequenceCondition
+	control    chan string
+	// closed when the sequence ended
+	done chan bool
+
+	// guarded by lock, for the status command
+	lock   *sync.Mutex
+	step   int
+	paused bool
+}
+
+// Running sequences keyed by the lowercase port name
+var spSequences = struct {
+	lock *sync.Mutex
+	runs map[string]*sequenceRun
+}{
+	lock: &sync.Mutex{},
+	runs: make(map[string]*sequenceRun),
+}
+
+// spSequence handles the sequence commands
+//
+//	sequence start <port> <sequence uuid>
+//	sequence pause <port>
+//	sequence resume <port>
+//	sequence abort <port>
+//	sequence status <port>
+func spSequence(c *connection, args []string) {
+	if len(args) < 3 {
+		spErr(c, "Usage: sequence start <port> <sequence> or sequence pause|resume|abort|status <port>")
+		return
+	}
+	action := strings.ToLower(args[1])
+	if action == "start" {
+		if len(args) < 4 {
+			spErr(c, "You did not specify the sequence to start")
+			return
+		}
+		if err := startSequence(args[2], args[3]); err != nil {
+			spErr(c, "Could not start the sequence on "+args[2]+": "+err.Error())
+		}
+		return
+	}
+
+	spSequences.lock.Lock()
+	run, found := spSequences.runs[strings.ToLower(args[2])]
+	spSequences.lock.Unlock()
+	if !found {
+		spErr(c, "No sequence is running on "+args[2])
+		return
+	}
+	switch action {
+	case "pause", "resume", "abort":
+		select {
+		case run.control <- action:
+		case <-run.done:
+			spErr(c, "No sequence is running on "+args[2])
+		}
+	case "status":
+		run.lock.Lock()
+		step, paused := run.step, run.paused
+		run.lock.Unlock()
+		desc := "The sequence is running."
+		if paused {
+			desc = "The sequence is paused."
+		}
+		run.report("SequenceStatus", desc, step)
+	default:
+		spErr(c, "Unknown sequence command "+args[1])
+	}
+}
+
+// startSequence loads the sequence from the database and starts it on the
+// port
+func startSequence(portname string, uuid string) error {
+	if _, found := findPortByName(portname); !found {
+		return errors.New("the port is not open")
+	}
+	sequence := models.Sequence{}
+	if err := db.One("UUID", uuid, &sequence); err != nil {
+		return err
+	}
+	run := &sequenceRun{
+		portname:   portname,
+		sequence:   sequence,
+		conditions: make(map[int]*sequenceCondition),
+		control:    make(chan string),
+		done:       make(chan bool),
+		lock:       &sync.Mutex{},
+	}
+	for i, step := range sequence.Steps {
+		if step.Type == "waitFor" {
+			condition, err := parseSequenceCondition(step.Condition)
+			if err != nil {
+				return fmt.Errorf("step %v: %v", i, err)
+			}
+			run.conditions[i] = condition
+		}
+		for _, target := range step.Branches {
+			if target < 0 || target > len(sequence.Steps) {
+				return fmt.Errorf("step %v: branches to unknown step %v", i, target)
+			}
+		}
+	}
+
+	key := strings.ToLower(portname)
+	spSequences.lock.Lock()
+	defer spSequences.lock.Unlock()
+	if _, found := spSequences.runs[key]; found {
+		return errors.New("a sequence is already running on the port")
+	}
+	spSequences.runs[key] = run
+	go run.run()
+	return nil
+}
+
+func (run *sequenceRun) run() {
+	defer func() {
+		spSequences.lock.Lock()
+		delete(spSequences.runs, strings.ToLower(run.portname))
+		spSequences.lock.Unlock()
+		close(run.done)
+	}()
+
+	run.report("SequenceStarted", "Started the sequence.", 0)
+	ticker := time.NewTicker(sequenceTick)
+	defer ticker.Stop()
+
+	step := 0
+	started := true
+	var elapsed, delta, held time.Duration
+	last := time.Now()
+	for step < len(run.sequence.Steps) {
+		if started {
+			run.setStep(step)
+			run.report("SequenceStep", "Started step.", step)
+			started = false
+			elapsed, held = 0, 0
+		}
+
+		select {
+		case action := <-run.control:
+			switch action {
+			case "pause":
+				run.setPaused(true)
+				run.report("SequencePaused", "Paused the sequence.", step)
+			case "resume":
+				run.setPaused(false)
+				run.report("SequenceResumed", "Resumed the sequence.", step)
+			case "abort":
+				run.report("SequenceAborted", "Aborted the sequence.", step)
+				return
+			}
+			last = time.Now()
+			continue
+		case now := <-ticker.C:
+			if run.isPaused() {
+				last = now
+				continue
+			}
+			delta = now.Sub(last)
+			elapsed += delta
+			last = now
+		}
+
+		next, done, err := run.evaluate(step, elapsed, delta, &held)
+		if err != nil {
+			run.report("SequenceFailed", err.Error(), step)
+			return
+		}
+		if done {
+			step = next
+			started = true
+		}
+	}
+	run.report("SequenceDone", "Completed the sequence.", step)
+}
+
+// evaluate checks a step, it returns the next step when the step is done.
+// elapsed is the time spent on the step and delta the time since the last
+// evaluation.
+func (run *sequenceRun) evaluate(step int, elapsed time.Duration, delta time.Duration, held *time.Duration) (int, bool, error) {
+	s := run.sequence.Steps[step]
+	p, found := findPortByName(run.portname)
+	if !found {
+		return 0, false, errors.New("the port was closed")
+	}
+
+	switch s.Type {
+	case "send":
+		sh.writeJson <- writeRequestJson{
+			p: p,
+			P: p.portConf.Name,
+			Data: []writeRequestJsonData{{
+				D:  strings.TrimRight(s.Command, "\n") + "\n",
+				Id: fmt.Sprintf("sequence-%v-%v", run.sequence.UUID, step),
+			}},
+		}
+		return step + 1, true, nil
+
+	case "wait":
+		return step + 1, elapsed >= seconds(s.Seconds), nil
+
+	case "waitFor":
+		bw, ok := p.bufferwatcher.(*Bufferflow3Devo)
+		if ok && run.conditions[step].holds(bw.Latest()) {
+			*held += delta
+		} else {
+			*held = 0
+		}
+		if *held >= seconds(s.Seconds) {
+			return step + 1, true, nil
+		}
+		if s.Timeout > 0 && elapsed >= seconds(s.Timeout) {
+			return 0, false, fmt.Errorf("%v did not hold within %v seconds", s.Condition, s.Timeout)
+		}
+		return 0, false, nil
+
+	case "branch":
+		status := ""
+		if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok {
+			status = bw.Latest()["Status"]
+		}
+		if target, found := s.Branches[status]; found {
+			return target, true, nil
+		}
+		return step + 1, true, nil
+	}
+	return 0, false, errors.New("unknown step type " + s.Type)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func (run *sequenceRun) setStep(step int) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	run.step = step
+}
+
+func (run *sequenceRun) setPaused(paused bool) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	run.paused = paused
+}
+
+func (run *sequenceRun) isPaused() bool {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	return run.paused
+}
+
+func (run *sequenceRun) report(cmd string, desc string, step int) {
+	report := sequenceReport{
+		Cmd:      cmd,
+		Desc:     desc,
+		Port:     run.portname,
+		Sequence: run.sequence.UUID,
+		Title:    run.sequence.Title,
+		Step:     step,
+	}
+	if step < len(run.sequence.Steps) {
+		report.Type = run.sequence.Steps[step].Type
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	} else {
+		log.Println("Could not send sequence report: " + err.Error())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSequenceCondition(t *testing.T) {
+	prepareDb(t)
+	tests := []struct {
+		condition string
+		valid     bool
+	}{
+		{"Temp1 >= SetT1 - 2", true},
+		{"Temp1>=200", true},
+		{"-Temp1 + 5 < -2.5", true},
+		{"RPM == 0", true},
+		{"FT != FTAVG", true},
+		{"Temp1 is hot", false},
+		{"Temp1 >= ", false},
+		{" >= 200", false},
+		{"Temp1 200 >= 5", false},
+		{"Status == Idle", false},
+		{"Unknown > 2", false},
+		{"Temp1 >= 2 * SetT1", false},
+	}
+	for _, test := range tests {
+		_, err := parseSequenceCondition(test.condition)
+		if (err == nil) != test.valid {
+			t.Errorf("parseSequenceCondition(%q) returned %v, want valid %v", test.condition, err, test.valid)
+		}
+	}
+}
+
+func TestSequenceConditionHolds(t *testing.T) {
+	prepareDb(t)
+	values := map[string]string{"Temp1": "198.5", "SetT1": "200", "RPM": "0", "FT": "1.75"}
+	tests := []struct {
+		condition string
+		holds     bool
+	}{
+		{"Temp1 >= SetT1 - 2", true},
+		{"Temp1 >= SetT1 - 1", false},
+		{"Temp1 > 198.5", false},
+		{"Temp1 < 198.6", true},
+		{"Temp1 <= -SetT1 + 398.5", true},
+		{"RPM == 0", true},
+		{"RPM != 0", false},
+		{"FT > 1.7 + 0.04", true},
+		// a column without a value never holds
+		{"Temp2 < 1000", false},
+		{"Temp2 != 0", false},
+	}
+	for _, test := range tests {
+		condition, err := parseSequenceCondition(test.condition)
+		if err != nil {
+			t.Fatalf("parseSequenceCondition(%q) failed: %v", test.condition, err)
+		}
+		if holds := condition.holds(values); holds != test.holds {
+			t.Errorf("%q holds %v, want %v", test.condition, holds, test.holds)
+		}
+	}
+}
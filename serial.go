@@ -5,6 +5,7 @@ package main
 import (
 	//"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	//"path/filepath"
@@ -19,6 +20,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	//"time"
 )
 
@@ -41,6 +43,12 @@ type writeRequestJsonData struct {
 	Id    string
 	Buf   string
 	Pause int
+	// milliseconds to wait for the device to acknowledge the command, 0
+	// waits forever
+	Timeout int
+	// how often an unacknowledged command is written again before it is
+	// reported as failed
+	Retries int
 }
 
 type qReportJson struct {
@@ -51,11 +59,13 @@ type qReportJson struct {
 }
 
 type qReportJsonData struct {
-	D     string
-	Id    string
-	Buf   string `json:"-"`
-	Parts int    `json:"-"`
-	Pause int
+	D       string
+	Id      string
+	Buf     string `json:"-"`
+	Parts   int    `json:"-"`
+	Pause   int
+	Timeout int
+	Retries int
 }
 
 type qReport struct {
@@ -68,8 +78,10 @@ type qReport struct {
 }
 
 type serialhub struct {
-	// Opened serial ports.
-	ports map[*serport]bool
+	// Opened serial ports, changed by the hub only. Other goroutines take
+	// a copy with openPorts.
+	ports     map[*serport]bool
+	portsLock *sync.Mutex
 
 	//open chan *io.ReadWriteCloser
 	//write chan *serport, chan []byte
@@ -107,6 +119,38 @@ type SpPortItem struct {
 	UsbVid                    string
 	UsbPid                    string
 	FeedRateOverride          float32
+	DataBits                  int
+	Parity                    string
+	StopBits                  string
+	RtsOn                     bool
+	DtrOn                     bool
+	FlowControl               string
+	IsRecording               bool
+	RecordingLogFile          string
+	IsCapturing               bool
+	Firmware                  string
+	Hardware                  string
+	MachineSerial             string
+}
+
+type openReport struct {
+	Cmd         string
+	Desc        string
+	Port        string
+	IsPrimary   bool
+	Baud        int
+	BufferType  string
+	DataBits    int
+	Parity      string
+	StopBits    string
+	RtsOn       bool
+	DtrOn       bool
+	FlowControl string
+	// identity of the device, empty when it did not identify itself yet, see
+	// the Identity report
+	Firmware      string
+	Hardware      string
+	MachineSerial string
 }
 
 var sh = serialhub{
@@ -116,6 +160,7 @@ var sh = serialhub{
 	register:   make(chan *serport),
 	unregister: make(chan *serport),
 	ports:      make(map[*serport]bool),
+	portsLock:  &sync.Mutex{},
 	reJsonTrim: regexp.MustCompile("sendjson"),
 }
 
@@ -131,19 +176,44 @@ func (sh *serialhub) run() {
 		select {
 		case p := <-sh.register:
 			log.Print("Registering a port: ", p.portConf.Name)
-			isPrimary := "false"
-			if p.IsPrimary {
-				isPrimary = "true"
-			}
-			h.broadcastSys <- []byte("{\"Cmd\":\"Open\",\"Desc\":\"Got register/open on port.\",\"Port\":\"" + p.portConf.Name + "\",\"IsPrimary\":" + isPrimary + ",\"Baud\":" + strconv.Itoa(p.portConf.Baud) + ",\"BufferType\":\"" + p.BufferType + "\"}")
+			identity := p.getIdentity()
+			report, _ := json.Marshal(openReport{
+				Cmd:         "Open",
+				Desc:        "Got register/open on port.",
+				Port:        p.portConf.Name,
+				IsPrimary:   p.IsPrimary,
+				Baud:        p.portConf.Baud,
+				BufferType:  p.BufferType,
+				DataBits:    p.portConf.DataBits,
+				Parity:      p.portConf.Parity,
+				StopBits:    p.portConf.StopBits,
+				RtsOn:       p.portConf.RtsOn,
+				DtrOn:       p.portConf.DtrOn,
+				FlowControl: p.portConf.FlowControl,
+
+				Firmware:      identity.Firmware,
+				Hardware:      identity.Hardware,
+				MachineSerial: identity.MachineSerial,
+			})
+			h.broadcastSys <- report
 			//log.Print(p.portConf.Name)
+			sh.portsLock.Lock()
 			sh.ports[p] = true
+			sh.portsLock.Unlock()
+			if p.resume != nil {
+				sendReconnectReport("Reconnected", "Reconnected to the lost port.", p, p.resume)
+			}
 		case p := <-sh.unregister:
 			log.Print("Unregistering a port: ", p.portConf.Name)
 			h.broadcastSys <- []byte("{\"Cmd\":\"Close\",\"Desc\":\"Got unregister/close on port.\",\"Port\":\"" + p.portConf.Name + "\",\"Baud\":" + strconv.Itoa(p.portConf.Baud) + "}")
+			sh.portsLock.Lock()
 			delete(sh.ports, p)
+			sh.portsLock.Unlock()
 			close(p.sendBuffered)
 			close(p.sendNoBuf)
+			if p.isLost && p.portConf.Reconnect {
+				go spReconnect(p)
+			}
 		case wrj := <-sh.writeJson:
 			// if the user sent in the commands as json
 			writeJson(wrj)
@@ -206,6 +276,8 @@ func writeJson(wrj writeRequestJson) {
 			// when a user sends in multiple lines in one command and we break it apart,
 			// just assume that the pause will be the same on subsequent lines
 			qrd.Pause = cmdJson.Pause
+			qrd.Timeout = cmdJson.Timeout
+			qrd.Retries = cmdJson.Retries
 
 			qReportDataArr = append(qReportDataArr, qrd)
 
@@ -225,11 +297,13 @@ func writeJson(wrj writeRequestJson) {
 
 	// now send off the commands to the appropriate channel
 	for _, qrd := range qReportDataArr {
+		auditCmd(wrj.p.portConf.Name, "Queued", qrd.Id, qrd.D, "")
 
 		if qrd.Buf == "Buf" {
 
 			//log.Println("Json sending to wr.p.sendBuffered")
-			wrj.p.sendBuffered <- Cmd{qrd.D, qrd.Id, false, false, qrd.Pause}
+			wrj.p.pending.push(qrd.Id, qrd.D)
+			wrj.p.sendBuffered <- Cmd{qrd.D, qrd.Id, false, false, qrd.Pause, qrd.Timeout, qrd.Retries, false}
 
 		} else {
 			//log.Println("Json sending to wr.p.sendNoBuf")
@@ -241,7 +315,7 @@ func writeJson(wrj writeRequestJson) {
 				log.Printf("The serial data got rewritten on a NoBuf cmd. new cmd:%v", qrd.D)
 			}
 
-			wrj.p.sendNoBuf <- Cmd{qrd.D, qrd.Id, true, false, qrd.Pause}
+			wrj.p.sendNoBuf <- Cmd{qrd.D, qrd.Id, true, false, qrd.Pause, qrd.Timeout, qrd.Retries, false}
 		}
 	}
 
@@ -272,9 +346,11 @@ func write(wr writeRequest, id string) {
 		//cmdIdCtr++
 		//cmdId := "fakeid-" + strconv.Itoa(cmdIdCtr)
 		cmdId := idArr[index]
+		auditCmd(wr.p.portConf.Name, "Queued", cmdId, cmdToSendToChannel, "")
 		if bufTypeArr[index] == "Buf" {
 			//log.Println("Send was normal send, so sending to wr.p.sendBuffered")
-			wr.p.sendBuffered <- Cmd{cmdToSendToChannel, cmdId, false, false, 0}
+			wr.p.pending.push(cmdId, cmdToSendToChannel)
+			wr.p.sendBuffered <- Cmd{cmdToSendToChannel, cmdId, false, false, 0, 0, 0, false}
 		} else {
 			//log.Println("Send was sendnobuf, so sending to wr.p.sendNoBuf")
 			// Need to see if we should rewrite the serial command though
@@ -283,7 +359,7 @@ func write(wr writeRequest, id string) {
 				cmdToSendToChannel = newCmd
 				log.Printf("The serial data got rewritten on a NoBuf. new cmd:%v", cmdToSendToChannel)
 			}
-			wr.p.sendNoBuf <- Cmd{cmdToSendToChannel, cmdId, true, false, 0}
+			wr.p.sendNoBuf <- Cmd{cmdToSendToChannel, cmdId, true, false, 0, 0, 0, false}
 		}
 	}
 
@@ -327,83 +403,16 @@ func createCommands(wr writeRequest, id string) ([]string, []string, []string) {
 	idArr := []string{}
 	for _, cmd := range cmds {
 
+		// commands that pause, unpause or wipe the buffer are not for the
+		// device
+		if wr.p.controlBuffer(cmd) {
+			continue
+		}
+
 		// push this cmd onto dataArr for reporting
 		dataArr = append(dataArr, cmd)
 		idArr = append(idArr, id)
 
-		// do extra check to see if certain command should wipe out
-		// the entire internal serial port buffer we're holding in wr.p.sendBuffered
-		wipeBuf := wr.p.bufferwatcher.SeeIfSpecificCommandsShouldWipeBuffer(cmd)
-		if wipeBuf {
-			log.Printf("We got a command that is asking us to wipe the sendBuffered buf. cmd:%v\n", cmd)
-			// just wipe out the current channel and create new
-			// hopefully garbage collection works here
-
-			// close the channel
-			//close(wr.p.sendBuffered)
-
-			// consume all stuff queued
-			func() {
-				ctr := 0
-				/*
-					for data := range wr.p.sendBuffered {
-						log.Printf("Consuming sendBuffered queue. d:%v\n", string(data))
-						ctr++
-					}*/
-
-				keepLooping := true
-				for keepLooping {
-					select {
-					case d, ok := <-wr.p.sendBuffered:
-						log.Printf("Consuming sendBuffered queue. ok:%v, d:%v, id:%v\n", ok, string(d.data), string(d.id))
-						ctr++
-						// since we just consumed a buffer item, we need to decrement bufcount
-						// we are doing this artificially because we artifically threw
-						// away what was in the bufer
-						wr.p.itemsInBuffer--
-						if ok == false {
-							keepLooping = false
-						}
-					default:
-						keepLooping = false
-						log.Println("Hit default in select clause")
-					}
-				}
-				log.Printf("Done consuming sendBuffered cmds. ctr:%v\n", ctr)
-			}()
-
-			// we still will likely have a sendBuffered that is in the BlockUntilReady()
-			// that we have to deal with so it doesn't send to the serial port
-			// when we release it
-			// send semaphore release if there is one on the BlockUntilReady()
-			// this method will release the BlockUntilReady() but with an unblock
-			// of type 2 which means cancel the send
-			wr.p.bufferwatcher.ReleaseLock()
-
-			// let user know we wiped queue
-			log.Printf("itemsInBuffer:%v\n", wr.p.itemsInBuffer)
-			h.broadcastSys <- []byte("{\"Cmd\":\"WipedQueue\",\"QCnt\":" + strconv.Itoa(wr.p.itemsInBuffer) + ",\"Port\":\"" + wr.p.portConf.Name + "\"}")
-
-		}
-
-		// do extra check to see if any specific commands should pause
-		// the buffer. this means we'll trigger a BlockUntilReady() block
-		pauseBuf := wr.p.bufferwatcher.SeeIfSpecificCommandsShouldPauseBuffer(cmd)
-		if pauseBuf {
-			log.Printf("We need to manually pause our internal buffer.\n")
-			wr.p.bufferwatcher.SetManualPaused(true)
-			wr.p.bufferwatcher.Pause()
-		}
-
-		// do extra check to see if any specific commands should unpause
-		// the buffer. this means we'll release the BlockUntilReady() block
-		unpauseBuf := wr.p.bufferwatcher.SeeIfSpecificCommandsShouldUnpauseBuffer(cmd)
-		if unpauseBuf {
-			log.Printf("We need to unpause our internal buffer.\n")
-			wr.p.bufferwatcher.SetManualPaused(false)
-			wr.p.bufferwatcher.Unpause()
-		}
-
 		// do extra check to see if certain commands for this buffer type
 		// should skip the internal serial port buffering
 		// for example ! on tinyg and grbl should skip
@@ -442,10 +451,124 @@ func createCommands(wr writeRequest, id string) ([]string, []string, []string) {
 
 	} // for loop on broken apart commands
 
-	return cmds, idArr, bufTypeArr
+	return dataArr, idArr, bufTypeArr
+}
+
+// controlBuffer pauses, unpauses or wipes the buffer when the buffer flow
+// says the command asks for it. It returns false for any other command.
+func (p *serport) controlBuffer(cmd string) bool {
+	switch {
+	case p.bufferwatcher.SeeIfSpecificCommandsShouldWipeBuffer(cmd):
+		// do extra check to see if certain command should wipe out
+		// the entire internal serial port buffer we're holding in p.sendBuffered
+		log.Printf("We got a command that is asking us to wipe the sendBuffered buf. cmd:%v\n", cmd)
+		p.wipeBuffer()
+
+	case p.bufferwatcher.SeeIfSpecificCommandsShouldPauseBuffer(cmd):
+		log.Printf("We need to manually pause our internal buffer.\n")
+		p.pauseBuffer()
+
+	case p.bufferwatcher.SeeIfSpecificCommandsShouldUnpauseBuffer(cmd):
+		log.Printf("We need to unpause our internal buffer.\n")
+		p.resumeBuffer()
+
+	default:
+		return false
+	}
+	return true
+}
+
+// pauseBuffer holds the commands that were not written yet, this means
+// we'll trigger a BlockUntilReady() block
+func (p *serport) pauseBuffer() {
+	p.bufferwatcher.Pause()
+	auditCmd(p.portConf.Name, "Paused", "", "", "")
+	sendBufferReport("Paused", "", p)
 }
 
-func spList() {
+// resumeBuffer lets the held commands go again, this means we'll release
+// the BlockUntilReady() block
+func (p *serport) resumeBuffer() {
+	p.bufferwatcher.Unpause()
+	auditCmd(p.portConf.Name, "Resumed", "", "", "")
+	sendBufferReport("Resumed", "", p)
+}
+
+// wipeBuffer throws away every command that was not written yet
+func (p *serport) wipeBuffer() {
+	// just wipe out the current channel and create new
+	// hopefully garbage collection works here
+
+	// close the channel
+	//close(p.sendBuffered)
+
+	// consume all stuff queued
+	func() {
+		ctr := 0
+		/*
+			for data := range p.sendBuffered {
+				log.Printf("Consuming sendBuffered queue. d:%v\n", string(data))
+				ctr++
+			}*/
+
+		keepLooping := true
+		for keepLooping {
+			select {
+			case d, ok := <-p.sendBuffered:
+				log.Printf("Consuming sendBuffered queue. ok:%v, d:%v, id:%v\n", ok, string(d.data), string(d.id))
+				ctr++
+				// since we just consumed a buffer item, we need to decrement bufcount
+				// we are doing this artificially because we artifically threw
+				// away what was in the bufer
+				p.itemsInBuffer--
+				if ok == false {
+					keepLooping = false
+				}
+			default:
+				keepLooping = false
+				log.Println("Hit default in select clause")
+			}
+		}
+		log.Printf("Done consuming sendBuffered cmds. ctr:%v\n", ctr)
+	}()
+	p.pending.clear()
+
+	// we still will likely have a sendBuffered that is in the BlockUntilReady()
+	// that we have to deal with so it doesn't send to the serial port
+	// when we release it
+	// send semaphore release if there is one on the BlockUntilReady()
+	// this method will release the BlockUntilReady() but with an unblock
+	// of type 2 which means cancel the send
+	p.bufferwatcher.ReleaseLock()
+
+	// let user know we wiped queue
+	log.Printf("itemsInBuffer:%v\n", p.itemsInBuffer)
+	auditCmd(p.portConf.Name, "WipedQueue", "", "", "")
+	h.broadcastSys <- []byte("{\"Cmd\":\"WipedQueue\",\"QCnt\":" + strconv.Itoa(p.itemsInBuffer) + ",\"Port\":\"" + p.portConf.Name + "\"}")
+}
+
+// spList broadcasts the serial port list, showAll lists the ports that are
+// not in the allowlist too
+func spList(showAll bool) {
+	spl := getPortList(showAll)
+	ls, err := json.MarshalIndent(spl, "", "\t")
+	if err != nil {
+		log.Println(err)
+		h.broadcastSys <- []byte("Error creating json on port list " +
+			err.Error())
+	} else {
+		//log.Print("Printing out json byte data...")
+		h.broadcastSys <- ls
+	}
+}
+
+// getPortList returns the allowed serial ports together with their open
+// state, baud rate, etc
+func getPortList(showAll bool) SpPortList {
+	allowlist := loadPortAllowlist()
+	if showAll {
+		allowlist.showAll = true
+	}
 
 	// call our os specific implementation of getting the serial list
 	list, _ := GetList()
@@ -455,7 +578,7 @@ func spList() {
 	// happen on windows in a fallback scenario where an
 	// open port can't be identified because it is locked,
 	// so just solve that by manually inserting
-	for port := range sh.ports {
+	for _, port := range sh.openPorts() {
 
 		isFound := false
 		for _, item := range list {
@@ -485,7 +608,7 @@ func spList() {
 
 	// now try to get the meta data for the ports. keep in mind this may fail
 	// to give us anything
-	metaports, err := GetMetaList()
+	metaports, _ := GetMetaList()
 	log.Printf("Got metadata on ports:%v", metaports)
 
 	ctr := 0
@@ -535,9 +658,27 @@ func spList() {
 			newPort.BufferAlgorithm = myport.BufferType
 			newPort.IsPrimary = myport.IsPrimary
 			newPort.FeedRateOverride = myport.feedRateOverride
+			newPort.DataBits = myport.portConf.DataBits
+			newPort.Parity = myport.portConf.Parity
+			newPort.StopBits = myport.portConf.StopBits
+			newPort.RtsOn = myport.portConf.RtsOn
+			newPort.DtrOn = myport.portConf.DtrOn
+			newPort.FlowControl = myport.portConf.FlowControl
+			newPort.IsCapturing = myport.capture != nil
+			identity := myport.getIdentity()
+			newPort.Ver = identity.version()
+			newPort.Firmware = identity.Firmware
+			newPort.Hardware = identity.Hardware
+			newPort.MachineSerial = identity.MachineSerial
+			if bw, ok := myport.bufferwatcher.(*Bufferflow3Devo); ok {
+				if logFile := bw.GetLogFile(); logFile != nil {
+					newPort.IsRecording = true
+					newPort.RecordingLogFile = logFile.UUID
+				}
+			}
 		}
 		//ls += "{ \"name\" : \"" + item.Name + "\", \"friendly\" : \"" + item.FriendlyName + "\" },\n"
-		if strings.EqualFold(newPort.UsbPid, DevoUsbPID) && strings.EqualFold(newPort.UsbVid, DevoUsbVID) {
+		if allowlist.allows(newPort) {
 			spl.SerialPorts[ctr] = newPort
 			ctr++
 		}
@@ -547,15 +688,7 @@ func spList() {
 	// debug and set default values
 	log.Printf("About to marshal the serial port list. spl:%v", spl)
 	spl.SerialPorts = spl.SerialPorts[:ctr]
-	ls, err := json.MarshalIndent(spl, "", "\t")
-	if err != nil {
-		log.Println(err)
-		h.broadcastSys <- []byte("Error creating json on port list " +
-			err.Error())
-	} else {
-		//log.Print("Printing out json byte data...")
-		h.broadcastSys <- ls
-	}
+	return spl
 }
 
 func setMetaData(pi *SpPortItem, metadata []OsSerialPort) {
@@ -604,13 +737,21 @@ func spListOld() {
 	h.broadcastSys <- []byte(ls)
 }*/
 
-func spErr(err string) {
+// spErr sends an error of a text command to the client that sent it only
+func spErr(c *connection, err string) {
 	log.Println("Sending err back: ", err)
 	//h.broadcastSys <- []byte(err)
-	h.broadcastSys <- []byte("{\"Error\" : \"" + err + "\"}")
+	sendReply(c, []byte("{\"Error\" : \""+err+"\"}"))
+}
+
+func spClose(c *connection, portname string) {
+	if err := closePort(portname); err != nil {
+		spErr(c, err.Error())
+	}
 }
 
-func spClose(portname string) {
+// closePort closes an open port, or stops reconnecting to a lost one
+func closePort(portname string) error {
 	// look up the registered port by name
 	// then call the close method inside serialport
 	// that should cause an unregister channel call back
@@ -621,13 +762,17 @@ func spClose(portname string) {
 	if isFound {
 		// we found our port
 		spHandlerClose(myport)
+	} else if spCancelReconnect(portname) {
+		// the port was lost and we were waiting for it to come back
+		log.Println("Stopped reconnecting to " + portname)
 	} else {
 		// we couldn't find the port, so send err
-		spErr("We could not find the serial port " + portname + " that you were trying to close.")
+		return errors.New("We could not find the serial port " + portname + " that you were trying to close.")
 	}
+	return nil
 }
 
-func spWriteJson(arg string) {
+func spWriteJson(c *connection, arg string) {
 
 	//log.Printf("spWriteJson. arg:%v\n", arg)
 
@@ -656,7 +801,7 @@ func spWriteJson(arg string) {
 
 	if err != nil {
 		log.Printf("Problem decoding json. giving up. json:%v, err:%v\n", arg, err)
-		spErr(fmt.Sprintf("Problem decoding json. giving up. json:%v, err:%v", arg, err))
+		spErr(c, fmt.Sprintf("Problem decoding json. giving up. json:%v, err:%v", arg, err))
 		return
 	}
 
@@ -666,7 +811,7 @@ func spWriteJson(arg string) {
 
 	if !isFound {
 		// we couldn't find the port, so send err
-		spErr("We could not find the serial port " + portname + " that you were trying to write to.")
+		spErr(c, "We could not find the serial port "+portname+" that you were trying to write to.")
 		return
 	}
 
@@ -677,7 +822,7 @@ func spWriteJson(arg string) {
 	sh.writeJson <- m
 }
 
-func spWrite(arg string) {
+func spWrite(c *connection, arg string) {
 	// we will get a string of comXX asdf asdf asdf
 	log.Println("Inside spWrite arg: " + arg)
 	arg = strings.TrimPrefix(arg, " ")
@@ -686,49 +831,64 @@ func spWrite(arg string) {
 	if len(args) != 3 {
 		errstr := "Could not parse send command: " + arg
 		log.Println(errstr)
-		spErr(errstr)
+		spErr(c, errstr)
 		return
 	}
 	portname := strings.Trim(args[1], " ")
 	//log.Println("The port to write to is:" + portname + "---")
 	//log.Println("The data is:" + args[2] + "---")
 
+	// see if args[0] is send or sendnobuf
+	if err := writePort(portname, args[2], args[0] != "sendnobuf"); err != nil {
+		spErr(c, err.Error())
+	}
+}
+
+// writePort sends data to an open port, through the buffer or straight to
+// the device
+func writePort(portname string, data string, buffer bool) error {
 	// see if we have this port open
 	myport, isFound := findPortByName(portname)
 
 	if !isFound {
 		// we couldn't find the port, so send err
-		spErr("We could not find the serial port " + portname + " that you were trying to write to.")
-		return
+		return errors.New("We could not find the serial port " + portname + " that you were trying to write to.")
 	}
 
 	// we found our port
 	// create our write request
 	var wr writeRequest
 	wr.p = myport
-
-	// see if args[0] is send or sendnobuf
-	if args[0] != "sendnobuf" {
-		// we were just given a "send" so buffer it
-		wr.buffer = true
-	} else {
+	wr.buffer = buffer
+	if !buffer {
 		log.Println("sendnobuf specified so wr.buffer is false")
-		wr.buffer = false
 	}
 
 	// include newline or not in the write? that is the question.
 	// for now lets skip the newline
 	//wr.d = []byte(args[2] + "\n")
-	wr.d = args[2] //[]byte(args[2])
+	wr.d = data //[]byte(args[2])
 
 	// send it to the write channel
 	sh.write <- wr
+	return nil
+}
 
+// openPorts returns the opened serial ports.
+// go-routine safe.
+func (sh *serialhub) openPorts() []*serport {
+	sh.portsLock.Lock()
+	defer sh.portsLock.Unlock()
+	ports := make([]*serport, 0, len(sh.ports))
+	for port := range sh.ports {
+		ports = append(ports, port)
+	}
+	return ports
 }
 
 func findPortByName(portname string) (*serport, bool) {
 	portnamel := strings.ToLower(portname)
-	for port := range sh.ports {
+	for _, port := range sh.openPorts() {
 		if strings.ToLower(port.portConf.Name) == portnamel {
 			// we found our port
 			//spHandlerClose(port)
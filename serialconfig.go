@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/3devo/dvconnector/models"
+	serial "github.com/bob-thomas/go-serial"
+)
+
+var validBaudRates = []int{
+	300, 600, 1200, 2400, 4800, 9600, 14400, 19200, 38400, 57600, 115200,
+	230400, 460800, 500000, 576000, 921600, 1000000, 1152000, 1500000,
+	2000000, 2500000, 3000000, 3500000, 4000000,
+}
+
+var parities = map[string]serial.Parity{
+	"none":  serial.NoParity,
+	"odd":   serial.OddParity,
+	"even":  serial.EvenParity,
+	"mark":  serial.MarkParity,
+	"space": serial.SpaceParity,
+}
+
+var stopBits = map[string]serial.StopBits{
+	"1":   serial.OneStopBit,
+	"1.5": serial.OnePointFiveStopBits,
+	"2":   serial.TwoStopBits,
+}
+
+var flowControls = []string{"none", "rtscts", "xonxoff"}
+
+// the default seconds without new data before a stream counts as stalled,
+// the Filament Maker sends a line every second
+const defaultStallTimeout = 10
+
+// defaultSerialConfig returns the 115200 8N1 settings the Filament Maker uses
+func defaultSerialConfig(portname string) *SerialConfig {
+	return &SerialConfig{
+		Name:         portname,
+		Baud:         115200,
+		DataBits:     8,
+		Parity:       "none",
+		StopBits:     "1",
+		RtsOn:        true,
+		DtrOn:        false,
+		FlowControl:  "none",
+		Capture:      *captureRaw,
+		StallTimeout: defaultStallTimeout,
+	}
+}
+
+// parseOptions applies the options of an open command to the config. Options
+// are key=value pairs (baud, databits, parity, stopbits, rts, dtr, flow,
+// stall, salvage, token) or the reconnect and capture keywords. Any other word
+// pulses dtr, like it always did.
+func (conf *SerialConfig) parseOptions(options []string) error {
+	for _, option := range options {
+		keyValue := strings.SplitN(option, "=", 2)
+		key := strings.ToLower(keyValue[0])
+		if len(keyValue) == 1 {
+			if key == "reconnect" {
+				conf.Reconnect = true
+			} else if key == "capture" {
+				conf.Capture = true
+			} else {
+				conf.DtrPulse = true
+			}
+			continue
+		}
+		if key == "token" {
+			// tokens are case sensitive
+			conf.Token = keyValue[1]
+			continue
+		}
+		value := strings.ToLower(keyValue[1])
+
+		var err error
+		switch key {
+		case "baud":
+			conf.Baud, err = strconv.Atoi(value)
+		case "databits":
+			conf.DataBits, err = strconv.Atoi(value)
+		case "parity":
+			conf.Parity = value
+		case "stopbits":
+			conf.StopBits = value
+		case "rts":
+			conf.RtsOn, err = parseOnOff(value)
+		case "dtr":
+			conf.DtrOn, err = parseOnOff(value)
+		case "flow":
+			conf.FlowControl = value
+		case "stall":
+			conf.StallTimeout, err = parseStallTimeout(value)
+		case "salvage":
+			conf.Salvage, err = parseOnOff(value)
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid option %v: %v", option, err)
+		}
+	}
+	return nil
+}
+
+func parseOnOff(value string) (bool, error) {
+	switch value {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, errors.New("expected on or off")
+}
+
+// parseStallTimeout parses the seconds of the stall option, off turns the
+// watchdog off
+func parseStallTimeout(value string) (float64, error) {
+	if value == "off" {
+		return -1, nil
+	}
+	timeout, err := strconv.ParseFloat(value, 64)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New("expected seconds or off")
+	}
+	return timeout, nil
+}
+
+// validate checks if the config describes a serial line we can open
+func (conf *SerialConfig) validate() error {
+	validBaud := false
+	for _, baud := range validBaudRates {
+		if conf.Baud == baud {
+			validBaud = true
+		}
+	}
+	if !validBaud {
+		return fmt.Errorf("unsupported baud rate %v", conf.Baud)
+	}
+	if conf.DataBits < 5 || conf.DataBits > 8 {
+		return fmt.Errorf("unsupported number of data bits %v, must be 5 to 8", conf.DataBits)
+	}
+	if _, found := parities[conf.Parity]; !found {
+		return fmt.Errorf("unsupported parity %v, must be none, odd, even, mark or space", conf.Parity)
+	}
+	if _, found := stopBits[conf.StopBits]; !found {
+		return fmt.Errorf("unsupported stop bits %v, must be 1, 1.5 or 2", conf.StopBits)
+	}
+	for _, flow := range flowControls {
+		if conf.FlowControl == flow {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported flow control %v, must be none, rtscts or xonxoff", conf.FlowControl)
+}
+
+// mode returns the serial library settings for this config
+func (conf *SerialConfig) mode() *serial.Mode {
+	return &serial.Mode{
+		BaudRate: conf.Baud,
+		DataBits: conf.DataBits,
+		Parity:   parities[conf.Parity],
+		StopBits: stopBits[conf.StopBits],
+		DTROn:    conf.DtrOn || conf.DtrPulse,
+	}
+}
+
+// loadSettings restores the settings last used for the given device. Dtr
+// is not restored, holding it on has to be asked for on every open.
+func (conf *SerialConfig) loadSettings(serialNumber string) {
+	if serialNumber == "" || db == nil {
+		return
+	}
+	settings := models.PortSettings{}
+	if err := db.One("SerialNumber", serialNumber, &settings); err != nil {
+		return
+	}
+	conf.Baud = settings.Baud
+	conf.DataBits = settings.DataBits
+	conf.Parity = settings.Parity
+	conf.StopBits = settings.StopBits
+	conf.RtsOn = settings.RtsOn
+	conf.FlowControl = settings.FlowControl
+	// settings saved before the watchdog existed have no stall timeout
+	if settings.StallTimeout != 0 {
+		conf.StallTimeout = settings.StallTimeout
+	}
+	conf.Salvage = settings.Salvage
+}
+
+// saveSettings remembers the settings for the given device
+func (conf *SerialConfig) saveSettings(serialNumber string) error {
+	if serialNumber == "" || db == nil {
+		return nil
+	}
+	return db.Save(&models.PortSettings{
+		SerialNumber: serialNumber,
+		Baud:         conf.Baud,
+		DataBits:     conf.DataBits,
+		Parity:       conf.Parity,
+		StopBits:     conf.StopBits,
+		RtsOn:        conf.RtsOn,
+		FlowControl:  conf.FlowControl,
+		StallTimeout: conf.StallTimeout,
+		Salvage:      conf.Salvage,
+	})
+}
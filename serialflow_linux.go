@@ -0,0 +1,46 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// flowControl sets hardware (rtscts) or software (xonxoff) flow control on a
+// port. The serial library explicitly disables both and keeps its file
+// descriptor to itself, but the settings belong to the tty. So the port is
+// opened once more, before the library takes exclusive access of it, and
+// the settings are changed through that descriptor while the port is open.
+type flowControl struct {
+	fd int
+}
+
+// openFlowControl opens the port for setting its flow control, it must be
+// closed with the port
+func openFlowControl(portname string) (*flowControl, error) {
+	fd, err := unix.Open(portname, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &flowControl{fd}, nil
+}
+
+// set turns the flow control on, it must be called after the serial library
+// opened the port
+func (f *flowControl) set(flow string) error {
+	termios, err := unix.IoctlGetTermios(f.fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	termios.Cflag &^= unix.CRTSCTS
+	termios.Iflag &^= unix.IXON | unix.IXOFF
+	switch flow {
+	case "rtscts":
+		termios.Cflag |= unix.CRTSCTS
+	case "xonxoff":
+		termios.Iflag |= unix.IXON | unix.IXOFF
+	}
+	return unix.IoctlSetTermios(f.fd, unix.TCSETS, termios)
+}
+
+func (f *flowControl) Close() error {
+	return unix.Close(f.fd)
+}
@@ -0,0 +1,23 @@
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// flowControl is only implemented on Linux, the serial library always
+// disables flow control
+type flowControl struct{}
+
+func openFlowControl(portname string) (*flowControl, error) {
+	return nil, errors.New("flow control is only supported on Linux")
+}
+
+func (f *flowControl) set(flow string) error {
+	return nil
+}
+
+func (f *flowControl) Close() error {
+	return nil
+}
@@ -57,6 +57,9 @@ func GetList() ([]OsSerialPort, error) {
 		arrPorts = newarrPorts
 	}
 
+	// simulated machines are always listed
+	arrPorts = append(arrPorts, simulatorPortList()...)
+
 	//log.Printf("Done doing GetList(). arrPorts:%v\n", arrPorts)
 
 	return arrPorts, err
@@ -67,7 +70,7 @@ func GetMetaList() ([]OsSerialPort, error) {
 	if err.Err != nil {
 		return nil, err.Err
 	}
-	return metaportlist, err.Err
+	return append(metaportlist, simulatorPortList()...), err.Err
 }
 
 func GetFriendlyName(portname string) string {
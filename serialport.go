@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	//"github.com/johnlauer/goserial"
 	"sync"
 
@@ -17,21 +18,40 @@ import (
 )
 
 type SerialConfig struct {
-	Name string
-	Baud int
-
-	// Size     int // 0 get translated to 8
-	// Parity   SomeNewTypeToGetCorrectDefaultOf_None
-	// StopBits SomeNewTypeToGetCorrectDefaultOf_1
-
-	// RTSFlowControl bool
-	// DTRFlowControl bool
-	// XONFlowControl bool
+	Name     string
+	Baud     int
+	DataBits int    // 5, 6, 7 or 8
+	Parity   string // none, odd, even, mark or space
+	StopBits string // 1, 1.5 or 2
 
 	// CRLFTranslate bool
 	// TimeoutStuff int
 	RtsOn bool
 	DtrOn bool
+	// Open with dtr on and drop it right after, which resets the device.
+	// The open command did this for any extra word before there were
+	// options.
+	DtrPulse bool `json:"-"`
+
+	// none, rtscts or xonxoff
+	FlowControl string
+
+	// Reopen the port when the device disappears and comes back
+	Reconnect bool
+
+	// Write the raw bytes read from the port to a capture file
+	Capture bool
+
+	// Seconds without new data before the stream counts as stalled, 0 or
+	// less turns the watchdog off
+	StallTimeout float64
+
+	// Keep the well-formed fields of corrupt lines instead of dropping them
+	Salvage bool
+
+	// Token to log in to a port shared by another DvConnector, it is not
+	// remembered with the settings
+	Token string `json:"-"`
 }
 
 type serport struct {
@@ -52,9 +72,30 @@ type serport struct {
 	// just so we don't show scary error messages
 	isClosing bool
 
+	// Set when the port went away without being closed, i.e. the usb
+	// cable got pulled
+	isLost bool
+
+	// usb serial number of the device, used to find it again after
+	// it got lost
+	serialNumber string
+
+	// set when this port is the reopened version of a lost port
+	resume *reconnectState
+
+	// raw capture of everything read from the port, nil when not capturing
+	capture *rawCapture
+
+	// what the device told about itself, see identify
+	identity     deviceIdentity
+	identityLock *sync.Mutex
+
 	// counter incremented on queue, decremented on write
 	itemsInBuffer int
 
+	// the commands waiting in sendBuffered, to list and cancel them
+	pending *pendingCmds
+
 	// buffered channel containing up to 25600 outbound messages.
 	sendBuffered chan Cmd
 
@@ -82,6 +123,12 @@ type Cmd struct {
 	skippedBuffer              bool
 	willHandleCompleteResponse bool
 	pause                      int
+	// acknowledgement timeout in milliseconds and retries, see ExpectAck
+	timeout int
+	retries int
+	// a command written again because it was not acknowledged in time, it
+	// already left the buffer
+	resend bool
 }
 
 type CmdComplete struct {
@@ -136,6 +183,9 @@ func (p *serport) reader() {
 		// so process the bytes if n > 0
 		if n > 0 {
 			//log.Print("Read " + strconv.Itoa(n) + " bytes ch: " + string(ch))
+			if p.capture != nil {
+				p.capture.write(ch[:n])
+			}
 			data := string(ch[:n])
 			//log.Print("The data i will convert to json is:")
 			//log.Print(data)
@@ -166,6 +216,7 @@ func (p *serport) reader() {
 
 			if err != nil {
 				log.Println(err)
+				p.isLost = true
 				h.broadcastSys <- []byte("Error reading on " + p.portConf.Name + " " +
 					err.Error() + " Closing port.")
 				h.broadcastSys <- []byte("{\"Cmd\":\"OpenFail\",\"Desc\":\"Got error reading on port. " + err.Error() + "\",\"Port\":\"" + p.portConf.Name + "\",\"Baud\":" + strconv.Itoa(p.portConf.Baud) + "}")
@@ -178,12 +229,16 @@ func (p *serport) reader() {
 			if err == nil {
 				diff := time.Since(timeCheckOpen)
 				if diff.Nanoseconds() < 1000000 {
+					p.isLost = true
 					p.isClosing = true
 				}
 				timeCheckOpen = time.Now()
 			}
 		}
 	}
+	if p.capture != nil {
+		p.capture.Close()
+	}
 	p.portIo.Close()
 }
 
@@ -207,6 +262,12 @@ func (p *serport) writerBuffered() {
 
 		log.Printf("Got p.sendBuffered. data:%v, id:%v, pause:%v\n", strings.Replace(string(data.data), "\n", "\\n", -1), string(data.id), data.pause)
 
+		if !p.pending.take(data.id, data.data) {
+			log.Println("This cmd got cancelled while it was waiting, dropping it")
+			p.itemsInBuffer--
+			continue
+		}
+
 		// we want to block here if we are being asked
 		// to pause.
 		goodToGo, willHandleCompleteResponse, newGcode := p.bufferwatcher.BlockUntilReady(string(data.data), data.id)
@@ -245,8 +306,10 @@ func (p *serport) writerNoBuf() {
 		// if we get here, we were able to write successfully
 		// to the serial port because it blocks until it can write
 
-		// decrement counter
-		p.itemsInBuffer--
+		// decrement counter, a resend was counted when it first went out
+		if !data.resend {
+			p.itemsInBuffer--
+		}
 		log.Printf("Items In SPJS Queue List:%v\n", p.itemsInBuffer)
 		//h.broadcastSys <- []byte("{\"Cmd\":\"Write\",\"QCnt\":" + strconv.Itoa(p.itemsInBuffer) + ",\"Byte\":" + strconv.Itoa(n2) + ",\"Port\":\"" + p.portConf.Name + "\"}")
 
@@ -267,10 +330,19 @@ func (p *serport) writerNoBuf() {
 		}
 		qwrJson, _ := json.Marshal(qwr)
 		h.broadcastSys <- qwrJson
+		auditCmd(p.portConf.Name, "Write", data.id, data.data, "")
+
+		// remember the command before the device can acknowledge it
+		if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok {
+			bw.Writing(data)
+		}
 
 		// FINALLY, OF ALL THE CODE IN THIS PROJECT
 		// WE TRULY/FINALLY GET TO WRITE TO THE SERIAL PORT!
 		_, err := p.portIo.Write([]byte(data.data)) // n2, err :=
+		if bw, ok := p.bufferwatcher.(*Bufferflow3Devo); ok && err == nil {
+			bw.ExpectAck(data)
+		}
 
 		// New Pause capability after we write. Added 9/23/15
 		// This was needed because many Atmel microcontrollers just plain drop serial data
@@ -287,6 +359,7 @@ func (p *serport) writerNoBuf() {
 			// Send fake cmd:"Complete" back
 			//strCmd := data.data
 			m := CmdComplete{"CompleteFake", data.id, p.portConf.Name, -1, data.data}
+			auditCmd(p.portConf.Name, "CompleteFake", data.id, "", "")
 			msgJson, err := json.Marshal(m)
 			if err == nil {
 				h.broadcastSys <- msgJson
@@ -311,19 +384,153 @@ func (p *serport) writerNoBuf() {
 	p.portIo.Close()
 }
 
-var spmutex = &sync.Mutex{}
-var spIsOpening = false
+// Lowercase names of the ports that are being opened or are open. A port is
+// claimed for as long as spOpen runs for it, so different ports can be opened
+// at the same time while a second open of the same port is refused.
+var spClaimed = struct {
+	lock  *sync.Mutex
+	ports map[string]bool
+}{
+	lock:  &sync.Mutex{},
+	ports: make(map[string]bool),
+}
 
-func spHandlerOpen(portname string, baud int, isSecondary bool, dtrOn bool) {
+// spClaimPort claims the port for opening. It returns false when the port
+// is already claimed.
+func spClaimPort(portname string) bool {
+	spClaimed.lock.Lock()
+	defer spClaimed.lock.Unlock()
+	key := strings.ToLower(portname)
+	if spClaimed.ports[key] {
+		return false
+	}
+	spClaimed.ports[key] = true
+	return true
+}
 
-	log.Print("Inside spHandler")
+func spReleasePort(portname string) {
+	spClaimed.lock.Lock()
+	delete(spClaimed.ports, strings.ToLower(portname))
+	spClaimed.lock.Unlock()
+}
+
+// openLocalPort opens a serial port of this machine with all the line
+// settings of conf
+func openLocalPort(conf *SerialConfig) (serial.Port, error) {
+	// the flow control has to be opened before the serial library locks
+	// the port
+	flow, err := openFlowControl(conf.Name)
+	if err != nil && conf.FlowControl != "none" {
+		return nil, errors.New("could not set flow control: " + err.Error())
+	}
+	// Needed for Arduino serial library
+	sp, err := serial.Open(conf.Name, conf.mode())
+	if err != nil {
+		if flow != nil {
+			flow.Close()
+		}
+		return nil, err
+	}
+	port := &localPort{Port: sp, flow: flow}
+	sp.ResetInputBuffer()
+	sp.ResetOutputBuffer()
+	if conf.DtrPulse && !conf.DtrOn {
+		// the port was opened with dtr on, dropping it resets the device
+		sp.SetDTR(false)
+	}
+	if conf.FlowControl != "rtscts" {
+		// with hardware flow control the driver owns rts
+		sp.SetRTS(conf.RtsOn)
+	}
+	if err := port.setFlowControl(conf.FlowControl); err != nil {
+		port.Close()
+		return nil, errors.New("could not set flow control: " + err.Error())
+	}
+	return port, nil
+}
+
+// flowController is a port whose flow control can be changed while it is
+// open
+type flowController interface {
+	setFlowControl(flow string) error
+}
 
-	if spIsOpening {
-		log.Println("We are currently in the middle of opening a port. Returning...")
+// localPort is a serial port of this machine, its flow control is set apart
+// from the serial library
+type localPort struct {
+	serial.Port
+	// nil when flow control is not supported
+	flow *flowControl
+}
+
+func (p *localPort) setFlowControl(flow string) error {
+	if p.flow == nil {
+		if flow != "none" {
+			return errors.New("flow control is not supported on this port")
+		}
+		return nil
+	}
+	return p.flow.set(flow)
+}
+
+func (p *localPort) Close() error {
+	if p.flow != nil {
+		p.flow.Close()
+	}
+	return p.Port.Close()
+}
+
+// spHandlerOpen opens a port with the given open command options. Settings
+// that are not given are the ones last used for the same device.
+func spHandlerOpen(portname string, options []string) {
+	conf, serialNumber, err := openConfig(portname, options)
+	if err != nil {
+		log.Print("Invalid open command " + err.Error())
+		h.broadcastSys <- []byte("{\"Cmd\":\"OpenFail\",\"Desc\":\"Invalid open command. " + err.Error() + "\",\"Port\":\"" + conf.Name + "\",\"Baud\":" + strconv.Itoa(conf.Baud) + "}")
 		return
 	}
-	spIsOpening = true
-	spmutex.Lock()
+	spOpen(conf, serialNumber, false, nil)
+}
+
+// openConfig returns the settings to open the port with and the serial
+// number of the device on it
+func openConfig(portname string, options []string) (*SerialConfig, string, error) {
+	conf := defaultSerialConfig(portname)
+	serialNumber := lookupSerialNumber(portname)
+	conf.loadSettings(serialNumber)
+	err := conf.parseOptions(options)
+	if err == nil {
+		err = conf.validate()
+	}
+	return conf, serialNumber, err
+}
+
+// spOpen opens the port described by conf and blocks until it gets closed
+// again. resume is set when this is a reconnect of a lost port. It returns
+// false when the port could not be opened.
+func spOpen(conf *SerialConfig, serialNumber string, isSecondary bool, resume *reconnectState) bool {
+	p := spStart(conf, serialNumber, isSecondary, resume)
+	if p == nil {
+		return false
+	}
+	p.run()
+	return true
+}
+
+// spStart opens the port described by conf, the port only gets going once
+// it runs. It returns nil when the port could not be opened.
+func spStart(conf *SerialConfig, serialNumber string, isSecondary bool, resume *reconnectState) *serport {
+
+	log.Print("Inside spHandler")
+
+	portname := conf.Name
+	baud := conf.Baud
+
+	if !spClaimPort(portname) {
+		log.Print("Port " + portname + " is already open or being opened")
+		h.broadcastSys <- []byte("{\"Cmd\":\"OpenFail\",\"Desc\":\"Port is already open or being opened.\",\"Port\":\"" + conf.Name + "\",\"Baud\":" + strconv.Itoa(conf.Baud) + "}")
+		return nil
+	}
 
 	var out bytes.Buffer
 
@@ -339,64 +546,88 @@ func spHandlerOpen(portname string, baud int, isSecondary bool, dtrOn bool) {
 		isPrimary = false
 	}
 
-	conf := &SerialConfig{Name: portname, Baud: baud, RtsOn: true}
-	conf.DtrOn = dtrOn
-
-	// Needed for Arduino serial library
-	mode := &serial.Mode{}
-	mode.BaudRate = baud
-	mode.DataBits = 8
-	mode.Parity = serial.NoParity
-	mode.StopBits = serial.OneStopBit
-	mode.DTROn = dtrOn
-
 	// Needed for original serial library
 	// sp, err := serial.OpenPort(conf)
-	// Needed for Arduino serial library
-	sp, err := serial.Open(portname, mode)
+	var sp serial.Port
+	var err error
+	if isNetworkPort(portname) {
+		sp, err = openNetworkPort(conf)
+	} else {
+		sp, err = openLocalPort(conf)
+	}
 	log.Print("Just tried to open port")
 	if err != nil {
 		//log.Fatal(err)
 		log.Print("Error opening port " + err.Error())
 		//h.broadcastSys <- []byte("Error opening port. " + err.Error())
 		h.broadcastSys <- []byte("{\"Cmd\":\"OpenFail\",\"Desc\":\"Error opening port. " + err.Error() + "\",\"Port\":\"" + conf.Name + "\",\"Baud\":" + strconv.Itoa(conf.Baud) + "}")
-		return
+		spReleasePort(portname)
+		return nil
 	}
 	log.Print("Opened port successfully")
-	sp.ResetInputBuffer()
-	sp.ResetOutputBuffer()
-	if dtrOn {
-		sp.SetDTR(false)
+	if err := conf.saveSettings(serialNumber); err != nil {
+		log.Print("Could not remember the port settings " + err.Error())
 	}
+	simulatorPortOpened(portname)
 	//p := &serport{send: make(chan []byte, 256), portConf: conf, portIo: sp}
 	// we can go up to 500,000 lines of gcode in the buffer
-	p := &serport{sendBuffered: make(chan Cmd, 500000), sendNoBuf: make(chan Cmd), portConf: conf, portIo: sp, serialPort: sp, BufferType: "3Devo", IsPrimary: isPrimary, IsSecondary: isSecondary, isFeedRateOverrideOn: false}
+	p := &serport{sendBuffered: make(chan Cmd, 500000), sendNoBuf: make(chan Cmd), portConf: conf, portIo: sp, serialPort: sp, BufferType: "3Devo", IsPrimary: isPrimary, IsSecondary: isSecondary, isFeedRateOverrideOn: false, pending: newPendingCmds()}
+	// remember the device so we can find it back if it gets lost
+	p.serialNumber = serialNumber
+	p.identityLock = &sync.Mutex{}
+	if conf.Capture {
+		capture, err := newRawCapture(conf)
+		if err != nil {
+			log.Print("Could not create raw capture " + err.Error())
+		} else {
+			p.capture = capture
+		}
+	}
 	// if user asked for a buffer watcher, i.e. tinyg/grbl then attach here
 
 	// nodemcu buffer only sends data back per line (which might be a bad call)
 	// and it only sends 1 line at a time to the device and releases the next line
 	// when it sees a > come back
 	bw := &Bufferflow3Devo{Name: "3devo", Port: portname}
+	bw.LogFile = recordingLogFile(portname, resume)
+	bw.OnIdentity = p.setIdentity
+	bw.Resend = p.resend
+	bw.StallTimeout = seconds(conf.StallTimeout)
+	bw.Salvage = conf.Salvage
 	bw.Init()
 	p.bufferwatcher = bw
 
-	sh.register <- p
-	defer func() { sh.unregister <- p }()
+	p.resume = resume
+	return p
+}
+
+// run starts the writers and the reader of an opened port and blocks until
+// it gets closed again
+func (p *serport) run() {
+	defer spReleasePort(p.portConf.Name)
+
 	// this is internally buffered thread to not send to serial port if blocked
 	go p.writerBuffered()
 	// this is thread to send to serial port regardless of block
 	go p.writerNoBuf()
-	//v1.89 moved unlock here
-	spIsOpening = false
-	spmutex.Unlock()
-	p.reader()
-	//	go p.reader()
+	readerDone := make(chan bool)
+	go func() {
+		p.reader()
+		close(readerDone)
+	}()
+	sh.register <- p
+	// the Open report goes out without an identity, the answer of the
+	// device is announced in an Identity report when it comes in
+	p.identify()
+	<-readerDone
 	//p.done = make(chan bool)
 	//<-p.done
 
-	// prior to 1.89 i had lock here.
-	//	spIsOpening = false
-	//	spmutex.Unlock()
+	if p.isLost {
+		// nobody closed the bufferflow, so do it here
+		p.bufferwatcher.Close()
+	}
+	sh.unregister <- p
 }
 
 func spHandlerCloseExperimental(p *serport) {
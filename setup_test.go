@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+	"github.com/asdine/storm"
+)
+
+// prepareDb opens a database holding the default column catalogue as the
+// database of the connector
+func prepareDb(t *testing.T) {
+	var err error
+	db, err = storm.Open(filepath.Join(t.TempDir(), "storm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		db = nil
+	})
+	db.Init(&models.Column{})
+	if err := seedColumns(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// simulatorColumns is the order in which the simulated firmware prints its
+// columns. Every column is present in defaultColumns.
+var simulatorColumns = []string{
+	"Time",
+	"SetT1", "Temp1", "dc1", "Err1",
+	"SetT2", "Temp2", "dc2", "Err2",
+	"SetT3", "Temp3", "dc3", "Err3",
+	"SetT4", "Temp4", "dc4", "Err4", "intT4",
+	"ExtCur", "ExtPWM", "ExtTmp", "Overht", "FAULT",
+	"SetRPM", "RPM", "FT", "FTAVG", "Puller", "MemFree", "Status",
+	"WndrSpd", "PosSpd", "Length", "Volume", "SpDia", "SpFill",
+}
+
+// simulatorSettable are the columns that can be changed by writing
+// "<column> <value>" to the simulated port
+var simulatorSettable = map[string]bool{
+	"SetT1":   true,
+	"SetT2":   true,
+	"SetT3":   true,
+	"SetT4":   true,
+	"SetRPM":  true,
+	"Puller":  true,
+	"WndrSpd": true,
+}
+
+const (
+	simulatorAmbient  = 21.0
+	simulatorInterval = time.Second
+	simulatorFirmware = "sim-1.0"
+	simulatorHardware = "sim-rev1"
+)
+
+// simulator is a virtual Filament Maker exposed through a pseudo-terminal
+type simulator struct {
+	Name         string
+	SerialNumber string
+
+	master *os.File
+	slave  *os.File
+
+	lock      *sync.Mutex
+	values    map[string]float64
+	status    string
+	bootTime  time.Time
+	corrupt   int
+	frozen    time.Time
+	connected bool
+	removed   bool
+	stop      chan bool
+}
+
+type simulatorList struct {
+	lock    *sync.Mutex
+	sims    []*simulator
+	counter int
+}
+
+var simulators = simulatorList{lock: &sync.Mutex{}}
+
+var (
+	reSimulatorCmd     = regexp.MustCompile(`^\s*([A-Za-z0-9]+)\s*[ =:]\s*(-?[0-9]+(\.[0-9]+)?)\s*$`)
+	reSimulatorNewLine = regexp.MustCompile(`\r?\n`)
+)
+
+// startSimulator creates a new simulated Filament Maker. When serialNumber
+// is empty a new one is generated.
+func startSimulator(serialNumber string) (*simulator, error) {
+	simulators.lock.Lock()
+	simulators.counter++
+	if serialNumber == "" {
+		serialNumber = fmt.Sprintf("SIM%05d", simulators.counter)
+	}
+	simulators.lock.Unlock()
+
+	sim := &simulator{
+		SerialNumber: serialNumber,
+		lock:         &sync.Mutex{},
+	}
+	if err := sim.connect(); err != nil {
+		return nil, err
+	}
+	simulators.lock.Lock()
+	simulators.sims = append(simulators.sims, sim)
+	simulators.lock.Unlock()
+	log.Printf("Started Filament Maker simulator %v on %v\n", sim.SerialNumber, sim.Name)
+	return sim, nil
+}
+
+// findSimulator looks up a connected simulator by its port name
+func findSimulator(portname string) (*simulator, bool) {
+	simulators.lock.Lock()
+	defer simulators.lock.Unlock()
+	for _, sim := range simulators.sims {
+		sim.lock.Lock()
+		found := sim.connected && strings.EqualFold(sim.Name, portname)
+		sim.lock.Unlock()
+		if found {
+			return sim, true
+		}
+	}
+	return nil, false
+}
+
+// simulatorPortList returns the connected simulators as serial ports
+// carrying the 3devo usb VID/PID
+func simulatorPortList() []OsSerialPort {
+	simulators.lock.Lock()
+	defer simulators.lock.Unlock()
+	list := []OsSerialPort{}
+	for _, sim := range simulators.sims {
+		sim.lock.Lock()
+		if sim.connected {
+			list = append(list, OsSerialPort{
+				Name:         sim.Name,
+				FriendlyName: "3devo Filament Maker simulator (" + strings.Replace(sim.Name, "/dev/", "", -1) + ")",
+				SerialNumber: sim.SerialNumber,
+				Manufacturer: "3devo",
+				Product:      "Filament Maker simulator",
+				IdVendor:     DevoUsbVID,
+				IdProduct:    DevoUsbPID,
+			})
+		}
+		sim.lock.Unlock()
+	}
+	return list
+}
+
+// simulatorPortOpened is called when a port got opened. Opening resets the
+// simulated board so the header is sent before any data.
+func simulatorPortOpened(portname string) {
+	if sim, ok := findSimulator(portname); ok {
+		sim.reboot()
+	}
+}
+
+// connect allocates a new pty for the simulator and starts streaming
+func (sim *simulator) connect() error {
+	master, slave, name, err := openPty()
+	if err != nil {
+		return err
+	}
+	sim.lock.Lock()
+	sim.master = master
+	sim.slave = slave
+	sim.Name = name
+	sim.connected = true
+	sim.stop = make(chan bool)
+	sim.lock.Unlock()
+
+	sim.boot()
+	go sim.reader(master)
+	go sim.run(sim.stop)
+	hotplugNotify()
+	return nil
+}
+
+// disconnect closes the pty which looks like an unplugged usb cable to
+// anyone that has the port opened
+func (sim *simulator) disconnect() {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	if !sim.connected {
+		return
+	}
+	sim.connected = false
+	close(sim.stop)
+	sim.master.Close()
+	sim.slave.Close()
+	log.Printf("Simulator %v disconnected from %v\n", sim.SerialNumber, sim.Name)
+	hotplugNotify()
+}
+
+// remove disconnects the simulator and forgets about it
+func (sim *simulator) remove() {
+	sim.disconnect()
+	sim.lock.Lock()
+	sim.removed = true
+	sim.lock.Unlock()
+
+	simulators.lock.Lock()
+	defer simulators.lock.Unlock()
+	for i, s := range simulators.sims {
+		if s == sim {
+			simulators.sims = append(simulators.sims[:i], simulators.sims[i+1:]...)
+			break
+		}
+	}
+}
+
+// reconnectAfter plugs the simulator back in after the given delay, most
+// likely on a different pty name
+func (sim *simulator) reconnectAfter(delay time.Duration) {
+	time.Sleep(delay)
+	sim.lock.Lock()
+	removed := sim.removed
+	sim.lock.Unlock()
+	if removed {
+		return
+	}
+	if err := sim.connect(); err != nil {
+		log.Printf("Simulator %v failed to reconnect: %v\n", sim.SerialNumber, err)
+		return
+	}
+	log.Printf("Simulator %v reconnected on %v\n", sim.SerialNumber, sim.Name)
+}
+
+// boot resets the simulated machine state and prints the startup banner
+// followed by the column header
+func (sim *simulator) boot() {
+	sim.lock.Lock()
+	temps := map[string]float64{}
+	for _, zone := range []string{"Temp1", "Temp2", "Temp3", "Temp4", "intT4"} {
+		temps[zone] = simulatorAmbient
+		if sim.values != nil {
+			temps[zone] = sim.values[zone]
+		}
+	}
+	sim.values = map[string]float64{
+		"MemFree": 1200,
+		"SpDia":   200,
+	}
+	for zone, temp := range temps {
+		sim.values[zone] = temp
+	}
+	sim.status = "Idle"
+	sim.bootTime = time.Now()
+	sim.lock.Unlock()
+
+	sim.writeLine("3devo Filament Maker simulator")
+	sim.writeLine(sim.identityLine())
+	sim.writeLine(strings.Join(simulatorColumns, "\t"))
+}
+
+func (sim *simulator) identityLine() string {
+	return "Firmware: " + simulatorFirmware + " Hardware: " + simulatorHardware + " Serial: " + sim.SerialNumber
+}
+
+// reboot emulates a firmware reset
+func (sim *simulator) reboot() {
+	log.Printf("Simulator %v rebooting\n", sim.SerialNumber)
+	sim.boot()
+}
+
+// corruptNext makes the next count data lines arrive corrupted
+func (sim *simulator) corruptNext(count int) {
+	sim.lock.Lock()
+	sim.corrupt += count
+	sim.lock.Unlock()
+}
+
+// freeze stops the data lines for a while without closing the port, like a
+// hanging firmware
+func (sim *simulator) freeze(duration time.Duration) {
+	sim.lock.Lock()
+	sim.frozen = time.Now().Add(duration)
+	sim.lock.Unlock()
+}
+
+func (sim *simulator) isFrozen() bool {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	return time.Now().Before(sim.frozen)
+}
+
+// setFault sets the FAULT bitmask and overheat flags reported by the machine
+func (sim *simulator) setFault(fault int, overheat int) {
+	sim.lock.Lock()
+	sim.values["FAULT"] = float64(fault)
+	sim.values["Overht"] = float64(overheat)
+	sim.lock.Unlock()
+}
+
+func (sim *simulator) run(stop chan bool) {
+	ticker := time.NewTicker(simulatorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if sim.isFrozen() {
+				continue
+			}
+			sim.step(simulatorInterval.Seconds())
+			sim.writeLine(sim.dataLine())
+		}
+	}
+}
+
+// step advances the simulated physics by dt seconds
+func (sim *simulator) step(dt float64) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	v := sim.values
+	v["Time"] = math.Floor(time.Since(sim.bootTime).Seconds())
+
+	heating := false
+	for zone := 1; zone <= 4; zone++ {
+		set := v["SetT"+strconv.Itoa(zone)]
+		temp := v["Temp"+strconv.Itoa(zone)]
+		target := set
+		if set <= 0 {
+			target = simulatorAmbient
+		}
+		temp += (target-temp)*0.08*dt + (rand.Float64()-0.5)*0.4
+		duty := 0.0
+		if set > 0 {
+			duty = math.Max(0, math.Min(100, (set-temp)*5))
+			if temp < set-5 {
+				heating = true
+			}
+		}
+		v["Temp"+strconv.Itoa(zone)] = temp
+		v["dc"+strconv.Itoa(zone)] = duty
+	}
+	v["intT4"] = v["Temp4"] - 4 + (rand.Float64()-0.5)*0.2
+
+	v["RPM"] += (v["SetRPM"] - v["RPM"]) * 0.3
+	v["ExtPWM"] = math.Min(100, v["RPM"]*0.6)
+	v["ExtCur"] = v["RPM"] * 0.05
+	v["ExtTmp"] = simulatorAmbient + v["RPM"]*0.3
+
+	if v["RPM"] > 0.5 {
+		v["FT"] = 1.75 + (rand.Float64()-0.5)*0.04
+		v["FTAVG"] += (v["FT"] - v["FTAVG"]) * 0.1
+		v["PosSpd"] = v["Puller"] / 10
+		length := v["Puller"] * dt / 60
+		v["Length"] += length
+		v["Volume"] += math.Pi * math.Pow(v["FT"]/2, 2) * length * 1000
+		v["SpFill"] = math.Min(100, v["Length"]/10)
+	} else {
+		v["FT"] = 0
+		v["PosSpd"] = 0
+	}
+
+	switch {
+	case v["FAULT"] != 0 || v["Overht"] != 0:
+		sim.status = "Fault"
+	case heating:
+		sim.status = "Heating"
+	case v["RPM"] > 0.5:
+		sim.status = "Extruding"
+	case v["SetT1"] > 0 || v["SetT2"] > 0 || v["SetT3"] > 0 || v["SetT4"] > 0:
+		sim.status = "Ready"
+	default:
+		sim.status = "Idle"
+	}
+}
+
+// dataLine formats the current state as a tab separated data line, mangled
+// if corruption was requested
+func (sim *simulator) dataLine() string {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	fields := make([]string, len(simulatorColumns))
+	for i, column := range simulatorColumns {
+		switch column {
+		case "Status":
+			fields[i] = sim.status
+		case "Time", "Overht", "FAULT", "MemFree":
+			fields[i] = strconv.Itoa(int(sim.values[column]))
+		default:
+			fields[i] = strconv.FormatFloat(sim.values[column], 'f', 2, 64)
+		}
+	}
+	line := strings.Join(fields, "\t")
+	if sim.corrupt > 0 {
+		sim.corrupt--
+		line = corruptLine(line)
+	}
+	return line
+}
+
+// corruptLine damages a line the way a flaky usb link would
+func corruptLine(line string) string {
+	pos := rand.Intn(len(line))
+	switch rand.Intn(3) {
+	case 0:
+		// drop a chunk of bytes
+		end := pos + 1 + rand.Intn(10)
+		if end > len(line) {
+			end = len(line)
+		}
+		return line[:pos] + line[end:]
+	case 1:
+		// garbage bytes
+		return line[:pos] + "\xff\x00#" + line[pos:]
+	default:
+		// truncate the line
+		return line[:pos]
+	}
+}
+
+func (sim *simulator) writeLine(line string) {
+	sim.lock.Lock()
+	master := sim.master
+	connected := sim.connected
+	sim.lock.Unlock()
+	if !connected {
+		return
+	}
+	// nobody might be reading the other side, so never block on it
+	master.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	master.Write([]byte(line + "\r\n"))
+}
+
+// reader handles the commands written to the simulated port
+func (sim *simulator) reader(master *os.File) {
+	buf := make([]byte, 256)
+	pending := ""
+	for {
+		n, err := master.Read(buf)
+		if err != nil {
+			return
+		}
+		pending += string(buf[:n])
+		lines := reSimulatorNewLine.Split(pending, -1)
+		pending = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			sim.handleCommand(strings.TrimSpace(line))
+		}
+	}
+}
+
+func (sim *simulator) handleCommand(cmd string) {
+	if cmd == "" {
+		return
+	}
+	if strings.EqualFold(cmd, "info") {
+		sim.writeLine(sim.identityLine())
+		return
+	}
+	if strings.EqualFold(cmd, "reboot") {
+		sim.writeLine("> " + cmd)
+		sim.reboot()
+		return
+	}
+	match := reSimulatorCmd.FindStringSubmatch(cmd)
+	if match == nil || !simulatorSettable[match[1]] {
+		sim.writeLine("> ERR unknown command: " + cmd)
+		return
+	}
+	value, _ := strconv.ParseFloat(match[2], 64)
+	sim.lock.Lock()
+	sim.values[match[1]] = value
+	sim.lock.Unlock()
+	sim.writeLine("> " + match[1] + " " + match[2])
+}
+
+type simulatorReport struct {
+	Cmd          string
+	Desc         string
+	Port         string
+	SerialNumber string
+}
+
+// spSimulate handles the simulate websocket command:
+//
+//	simulate add [serialnumber]
+//	simulate remove|reboot <port>
+//	simulate corrupt <port> [lines]
+//	simulate disconnect <port> [seconds]
+//	simulate freeze <port> [seconds]
+//	simulate fault <port> <fault> [overheat]
+func spSimulate(c *connection, args []string) {
+	if len(args) < 2 {
+		spErr(c, "You did not specify a simulate action")
+		return
+	}
+	action := strings.ToLower(args[1])
+	if action == "add" {
+		serialNumber := ""
+		if len(args) > 2 {
+			serialNumber = args[2]
+		}
+		sim, err := startSimulator(serialNumber)
+		if err != nil {
+			spErr(c, "Could not start simulator: "+err.Error())
+			return
+		}
+		sendSimulatorReport("Started simulator", sim)
+		return
+	}
+
+	if len(args) < 3 {
+		spErr(c, "You did not specify a simulator port")
+		return
+	}
+	sim, ok := findSimulator(args[2])
+	if !ok {
+		spErr(c, "We could not find the simulator "+args[2])
+		return
+	}
+	intArg := func(index int, def int) (int, error) {
+		if len(args) <= index {
+			return def, nil
+		}
+		return strconv.Atoi(args[index])
+	}
+
+	var err error
+	switch action {
+	case "remove":
+		sim.remove()
+		sendSimulatorReport("Removed simulator", sim)
+	case "reboot":
+		sim.reboot()
+		sendSimulatorReport("Rebooted simulator", sim)
+	case "corrupt":
+		var count int
+		if count, err = intArg(3, 1); err == nil {
+			sim.corruptNext(count)
+			sendSimulatorReport(fmt.Sprintf("Corrupting the next %v lines", count), sim)
+		}
+	case "disconnect":
+		var seconds int
+		if seconds, err = intArg(3, 3); err == nil {
+			sendSimulatorReport(fmt.Sprintf("Disconnecting simulator for %v seconds", seconds), sim)
+			sim.disconnect()
+			go sim.reconnectAfter(time.Duration(seconds) * time.Second)
+		}
+	case "freeze":
+		var seconds int
+		if seconds, err = intArg(3, 15); err == nil {
+			sim.freeze(time.Duration(seconds) * time.Second)
+			sendSimulatorReport(fmt.Sprintf("Freezing simulator for %v seconds", seconds), sim)
+		}
+	case "fault":
+		var fault, overheat int
+		if len(args) < 4 {
+			err = errors.New("no fault specified")
+		} else if fault, err = intArg(3, 0); err == nil {
+			if overheat, err = intArg(4, 0); err == nil {
+				sim.setFault(fault, overheat)
+				sendSimulatorReport(fmt.Sprintf("Set FAULT %v Overht %v", fault, overheat), sim)
+			}
+		}
+	default:
+		err = errors.New("unknown action " + action)
+	}
+	if err != nil {
+		spErr(c, "Could not parse simulate command: "+err.Error())
+	}
+}
+
+func sendSimulatorReport(desc string, sim *simulator) {
+	sim.lock.Lock()
+	report := simulatorReport{"Simulator", desc, sim.Name, sim.SerialNumber}
+	sim.lock.Unlock()
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}
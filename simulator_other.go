@@ -0,0 +1,13 @@
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openPty is only implemented on Linux
+func openPty() (*os.File, *os.File, string, error) {
+	return nil, nil, "", errors.New("the simulator is only supported on Linux")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// The live state of the ports is served over REST too, so scripts and
+// dashboards can poll it without speaking the websocket protocol. The ports
+// are listed like the list command does, the snapshot of a port holds the
+// last data line of the device.
+
+type portSnapshot struct {
+	Name             string
+	IsOpen           bool
+	IsRecording      bool
+	RecordingLogFile string
+	Status           string
+	Header           []string
+	// the typed values of the last data line keyed by column name
+	Values map[string]interface{}
+	// device time of the last data line
+	Time string
+	// unix time the last data line came in, 0 when none came in yet
+	UpdatedAt int64
+	// the corrupt lines since the port was opened
+	Corruption corruptionCounts
+}
+
+// Snapshot returns the header, the last data line, the Status and the corrupt
+// lines of the port.
+// go-routine safe.
+func (b *Bufferflow3Devo) Snapshot() portSnapshot {
+	corruption := b.Corruption()
+	b.valuesLock.Lock()
+	defer b.valuesLock.Unlock()
+	snapshot := portSnapshot{
+		Name:       b.Port,
+		IsOpen:     true,
+		Status:     b.status.status,
+		Header:     []string{},
+		Values:     schemaReport{Columns: b.columns}.telemetry(b.latest, nil).Values,
+		Corruption: corruption,
+	}
+	snapshot.Header = append(snapshot.Header, b.header...)
+	if len(b.latest) > 0 {
+		snapshot.Time = b.latest[0]
+		snapshot.UpdatedAt = b.latestAt.Unix()
+	}
+	if logFile := b.GetLogFile(); logFile != nil {
+		snapshot.IsRecording = true
+		snapshot.RecordingLogFile = logFile.UUID
+	}
+	return snapshot
+}
+
+// isPortName tells whether name refers to the port, the device path can be
+// left out of a unix port name as it does not fit in an url
+func isPortName(name string, portname string) bool {
+	return strings.EqualFold(name, portname) || strings.EqualFold(name, path.Base(portname))
+}
+
+// getPortSnapshot returns the snapshot of an open port, or an empty one for
+// a port that is not open
+func getPortSnapshot(name string) (portSnapshot, bool) {
+	for _, port := range sh.openPorts() {
+		if !isPortName(name, port.portConf.Name) {
+			continue
+		}
+		if bw, ok := port.bufferwatcher.(*Bufferflow3Devo); ok {
+			return bw.Snapshot(), true
+		}
+		return portSnapshot{Name: port.portConf.Name, IsOpen: true, Header: []string{}, Values: map[string]interface{}{}}, true
+	}
+	for _, item := range getPortList(false).SerialPorts {
+		if isPortName(name, item.Name) {
+			return portSnapshot{Name: item.Name, Header: []string{}, Values: map[string]interface{}{}}, true
+		}
+	}
+	return portSnapshot{}, false
+}
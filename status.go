@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+// The Status column tells what the Filament Maker is doing, i.e. Idle,
+// Heating, Extruding, Ready or Fault. Every change of it is sent as a
+// StatusChanged event and recorded as a models.StatusTransition with the
+// log file. The time spent in a Status is taken from the Time column of the
+// device, or from the clock when the device rebooted in between. The time
+// recorded with the log file starts when the recording does.
+
+type statusChangedReport struct {
+	Cmd      string
+	Port     string
+	Previous string
+	Status   string
+	// device time of the first line with the new Status
+	Time string
+	// seconds spent in the previous Status
+	Seconds float64
+}
+
+// statusMachine tracks the Status of a port, it is used by the input loop
+// only. The status is written under valuesLock so snapshots can read it.
+type statusMachine struct {
+	status string
+	since  statusClock
+	// when the Status was first recorded to the log file, a recording that
+	// starts in the middle of a Status only counts the time from there
+	recordedSince statusClock
+	// log file the current Status was recorded to
+	logFileUUID string
+}
+
+// statusClock is the moment of a data line by the clock and by the device
+type statusClock struct {
+	at         time.Time
+	deviceTime float64
+	hasTime    bool
+}
+
+// secondsUntil returns the seconds from the clock up to now, by the device
+// time unless the device rebooted in between
+func (c statusClock) secondsUntil(now statusClock) float64 {
+	if now.hasTime && c.hasTime && now.deviceTime >= c.deviceTime {
+		return now.deviceTime - c.deviceTime
+	}
+	return now.at.Sub(c.at).Seconds()
+}
+
+// trackStatus feeds the Status and device time of a data line to the state
+// machine
+func (b *Bufferflow3Devo) trackStatus(status string, deviceTime string) {
+	m := &b.status
+	// a salvaged line can miss its Status
+	if status == "" {
+		return
+	}
+	logFile := b.GetLogFile()
+	recorded := logFile != nil && logFile.UUID == m.logFileUUID
+	if status == m.status && (recorded || logFile == nil) {
+		if logFile == nil {
+			m.logFileUUID = ""
+		}
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(deviceTime, 64)
+	now := statusClock{time.Now(), seconds, err == nil}
+	transition := models.StatusTransition{
+		To:        status,
+		Time:      deviceTime,
+		Timestamp: now.at.Unix(),
+	}
+	if status != m.status {
+		previousSeconds := 0.0
+		if m.status != "" {
+			previousSeconds = m.since.secondsUntil(now)
+			transition.From = m.status
+			transition.Seconds = m.recordedSince.secondsUntil(now)
+		}
+		log.Printf("Status of %v changed from %v to %v\n", b.Port, m.status, status)
+		sendStatusChanged(statusChangedReport{
+			Cmd:      "StatusChanged",
+			Port:     b.Port,
+			Previous: m.status,
+			Status:   status,
+			Time:     deviceTime,
+			Seconds:  previousSeconds,
+		})
+		b.valuesLock.Lock()
+		m.status, m.since = status, now
+		b.valuesLock.Unlock()
+	}
+
+	// the recording started in this Status
+	if !recorded && logFile != nil {
+		transition.From, transition.Seconds = "", 0
+	}
+	m.recordedSince = now
+	m.logFileUUID = ""
+	if logFile != nil {
+		m.logFileUUID = logFile.UUID
+		transition.LogFileUUID = logFile.UUID
+		if err := env.Db.Save(&transition); err != nil {
+			log.Println("Can't save status transition of " + logFile.GetFileName() + ": " + err.Error())
+		}
+	}
+}
+
+func sendStatusChanged(report statusChangedReport) {
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		sendPortMessage(report.Port, bytes)
+	}
+}
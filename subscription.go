@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+)
+
+// A client only gets the messages of the topics it subscribed to:
+//
+//	telemetry  data lines, headers and schemas of the ports
+//	commands   the text commands of all clients, a command belongs to the
+//	           port it names
+//	alarms     alarms being raised, cleared and acknowledged
+//	system     everything else, i.e. Open, Close and errors
+//
+// A topic followed by a port, i.e. telemetry:COM3, only matches the messages
+// of that port. Clients start subscribed to * which matches everything, like
+// before there were topics, or to the topics of /ws?subscribe=a,b. They
+// change their topics with the subscribe and unsubscribe commands:
+//
+//	subscribe <topic>
+//	unsubscribe <topic>
+//
+// Replies to json commands, the reports of the alarms, queue and audit text
+// commands and the errors of text commands always go to the client that sent
+// them.
+
+const (
+	topicAll       = "*"
+	topicTelemetry = "telemetry"
+	topicCommands  = "commands"
+	topicAlarms    = "alarms"
+	topicSystem    = "system"
+)
+
+var topicKinds = []string{topicTelemetry, topicCommands, topicAlarms, topicSystem}
+
+type subscriptionsReport struct {
+	Cmd    string
+	Topics []string
+}
+
+// subscription changes the topics of a connection on the hub
+type subscription struct {
+	c         *connection
+	topic     string
+	subscribe bool
+	// gets the topics of the connection afterwards, the hub sends a
+	// Subscriptions report to the connection when it is nil
+	done chan []string
+}
+
+// parseTopic validates a topic and returns it the way connections store it
+func parseTopic(topic string) (string, error) {
+	if topic == topicAll {
+		return topic, nil
+	}
+	parts := strings.SplitN(topic, ":", 2)
+	kind := strings.ToLower(parts[0])
+	for _, known := range topicKinds {
+		if kind != known {
+			continue
+		}
+		if len(parts) == 1 {
+			return kind, nil
+		}
+		if parts[1] == "" {
+			break
+		}
+		// port names are matched like findPortByName does
+		return kind + ":" + strings.ToLower(parts[1]), nil
+	}
+	return "", errors.New("Unknown topic " + topic + ", use " + strings.Join(topicKinds, ", ") + " or " + topicAll)
+}
+
+// newTopics returns the topics of a new connection from the comma separated
+// list of its query, unknown topics are left out
+func newTopics(query string) map[string]bool {
+	topics := make(map[string]bool)
+	for _, topic := range strings.Split(query, ",") {
+		if topic = strings.TrimSpace(topic); topic == "" {
+			continue
+		}
+		parsed, err := parseTopic(topic)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		topics[parsed] = true
+	}
+	if query == "" {
+		topics[topicAll] = true
+	}
+	return topics
+}
+
+// subscribed tells whether the connection gets the messages of a topic for
+// the port, the port is empty when the message is not about one. Used by the
+// hub only.
+func (c *connection) subscribed(kind string, port string) bool {
+	if c.topics[topicAll] || c.topics[kind] {
+		return true
+	}
+	return port != "" && c.topics[kind+":"+strings.ToLower(port)]
+}
+
+// subscriptionTopics lists the topics of the connection. Used by the hub
+// only.
+func (c *connection) subscriptionTopics() []string {
+	topics := []string{}
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// topicOf returns the topic and port of a system message
+func topicOf(message []byte) (string, string) {
+	m := struct {
+		Cmd  string
+		P    string
+		Port string
+	}{}
+	if err := json.Unmarshal(message, &m); err != nil {
+		return topicSystem, ""
+	}
+	port := m.Port
+	if port == "" {
+		port = m.P
+	}
+	switch {
+	case m.Cmd == "" && m.P != "":
+		// a line of the device that is no data, i.e. the header
+		return topicTelemetry, port
+	case m.Cmd == "Schema" || m.Cmd == "SchemaChanged":
+		return topicTelemetry, port
+	case strings.HasPrefix(m.Cmd, "Alarm"):
+		return topicAlarms, port
+	}
+	return topicSystem, port
+}
+
+// portArgs is the position of the port in the text commands that name one
+var portArgs = map[string]int{
+	"open":      1,
+	"close":     1,
+	"send":      1,
+	"sendnobuf": 1,
+	"record":    2,
+	"queue":     1,
+	"cancel":    1,
+	"pause":     1,
+	"resume":    1,
+	"flush":     1,
+	"alarms":    1,
+	"audit":     1,
+	"share":     1,
+	"unshare":   1,
+	"sequence":  2,
+	"simulate":  2,
+}
+
+// commandPort returns the port a text command is about, or an empty string
+// when it names none
+func commandPort(message []byte) string {
+	args := strings.Fields(string(message))
+	if len(args) == 0 {
+		return ""
+	}
+	command := strings.ToLower(args[0])
+	if command == "sendjson" {
+		m := struct {
+			P string
+		}{}
+		json.Unmarshal([]byte(strings.TrimSpace(string(message)[len(args[0]):])), &m)
+		return m.P
+	}
+	if command == "simulate" && len(args) > 1 && strings.ToLower(args[1]) == "add" {
+		// names the serial number of the new simulator
+		return ""
+	}
+	if i, found := portArgs[command]; found && i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// spSubscribe handles the subscribe and unsubscribe commands of a client,
+// errors only go to that client
+func spSubscribe(c *connection, args []string) {
+	if len(args) < 2 {
+		sendReply(c, []byte("{\"Error\" : \"You did not specify a topic\"}"))
+		return
+	}
+	topic, err := parseTopic(args[1])
+	if err != nil {
+		bytes, _ := json.Marshal(map[string]string{"Error": err.Error()})
+		sendReply(c, bytes)
+		return
+	}
+	h.subscriptions <- subscription{c: c, topic: topic, subscribe: strings.ToLower(args[0]) == "subscribe"}
+}
+
+// isSubscriptionCommand tells whether a text command changes the topics of
+// the client that sent it
+func isSubscriptionCommand(message []byte) bool {
+	args := strings.Fields(strings.ToLower(string(message)))
+	return len(args) > 0 && (args[0] == "subscribe" || args[0] == "unsubscribe")
+}
+
+// subscribe applies a subscription on the hub
+func (h *hub) subscribe(s subscription) {
+	if s.subscribe {
+		s.c.topics[s.topic] = true
+	} else {
+		delete(s.c.topics, s.topic)
+	}
+	topics := s.c.subscriptionTopics()
+	if s.done != nil {
+		s.done <- topics
+		return
+	}
+	bytes, err := json.Marshal(subscriptionsReport{Cmd: "Subscriptions", Topics: topics})
+	if err == nil {
+		h.sendTo(s.c, bytes)
+	}
+}
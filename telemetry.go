@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+// Besides the raw lines of DataPerLine the data lines of a Filament Maker
+// are sent as typed telemetry, an object keyed by the column names of the
+// header. A client picks the format when it connects with
+// /ws?telemetry=raw|typed|both, raw is the default for older clients. The
+// schema of every open port is sent on connect and whenever a header comes
+// in.
+
+const (
+	telemetryRaw   = "raw"
+	telemetryTyped = "typed"
+	telemetryBoth  = "both"
+)
+
+type schemaColumn struct {
+	Name string
+	// number or text
+	Type      string
+	Unit      string `json:",omitempty"`
+	Precision int
+}
+
+type schemaReport struct {
+	Cmd     string
+	P       string
+	Columns []schemaColumn
+}
+
+// schemaChangedReport is sent when the device prints a header with other
+// columns, i.e. after a firmware update
+type schemaChangedReport struct {
+	Cmd     string
+	P       string
+	Added   []string
+	Removed []string
+	Columns []schemaColumn
+}
+
+type telemetryMessage struct {
+	Cmd       string
+	P         string
+	Values    map[string]interface{}
+	Anomalies []anomaly `json:",omitempty"`
+}
+
+// telemetryBroadcast is a data line in both formats, the hub sends each
+// client the format it asked for. The other messages of a port go the same
+// way without a typed format so clients get them in the order the port
+// produced them, they are routed like system messages.
+type telemetryBroadcast struct {
+	port  string
+	raw   []byte
+	typed []byte
+}
+
+// sendPortMessage sends a message of a port that is no data line in order
+// with its data lines
+func sendPortMessage(port string, message []byte) {
+	h.broadcastTelemetry <- telemetryBroadcast{port: port, raw: message}
+}
+
+// Schemas of the open ports keyed by the lowercase port name
+var spSchemas = struct {
+	lock    *sync.Mutex
+	schemas map[string][]byte
+}{
+	lock:    &sync.Mutex{},
+	schemas: make(map[string][]byte),
+}
+
+// newSchema types the columns of the header with the column catalogue,
+// unknown columns are text
+func newSchema(portname string, header []string, knownColumns map[string]models.Column) schemaReport {
+	schema := schemaReport{Cmd: "Schema", P: portname, Columns: []schemaColumn{}}
+	for _, name := range header {
+		column := schemaColumn{Name: name, Type: "text"}
+		if known, found := knownColumns[name]; found {
+			column.Type = known.Type
+			column.Unit = known.Unit
+			column.Precision = known.Precision
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+	return schema
+}
+
+// telemetry converts a validated data line to a typed message
+func (schema schemaReport) telemetry(line []string, anomalies []anomaly) telemetryMessage {
+	m := telemetryMessage{Cmd: "Telemetry", P: schema.P, Values: make(map[string]interface{}), Anomalies: anomalies}
+	for i, column := range schema.Columns {
+		if i >= len(line) {
+			break
+		}
+		value := strings.TrimSpace(line[i])
+		if value == "" {
+			// the field was missing from a salvaged line
+			m.Values[column.Name] = nil
+			continue
+		}
+		if column.Type == "number" {
+			if number, err := strconv.ParseFloat(value, 64); err == nil {
+				m.Values[column.Name] = number
+				continue
+			}
+		}
+		m.Values[column.Name] = value
+	}
+	return m
+}
+
+// diffSchema returns the columns that were added and removed and whether
+// the columns changed at all, moving a column is a change too
+func diffSchema(previous schemaReport, schema schemaReport) ([]string, []string, bool) {
+	names := func(schema schemaReport) map[string]bool {
+		m := make(map[string]bool)
+		for _, column := range schema.Columns {
+			m[column.Name] = true
+		}
+		return m
+	}
+	previousNames, newNames := names(previous), names(schema)
+	added, removed := []string{}, []string{}
+	changed := len(previous.Columns) != len(schema.Columns)
+	for i, column := range schema.Columns {
+		if !previousNames[column.Name] {
+			added = append(added, column.Name)
+		}
+		if i < len(previous.Columns) && previous.Columns[i].Name != column.Name {
+			changed = true
+		}
+	}
+	for _, column := range previous.Columns {
+		if !newNames[column.Name] {
+			removed = append(removed, column.Name)
+		}
+	}
+	return added, removed, changed
+}
+
+func sendSchemaChanged(schema schemaReport, added []string, removed []string) {
+	report := schemaChangedReport{
+		Cmd:     "SchemaChanged",
+		P:       schema.P,
+		Added:   added,
+		Removed: removed,
+		Columns: schema.Columns,
+	}
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		sendPortMessage(schema.P, bytes)
+	}
+}
+
+// setSchema remembers the schema of a port for new clients and sends it to
+// the connected ones
+func setSchema(schema schemaReport) {
+	bytes, err := json.Marshal(schema)
+	if err != nil {
+		log.Println("Could not send schema: " + err.Error())
+		return
+	}
+	spSchemas.lock.Lock()
+	spSchemas.schemas[strings.ToLower(schema.P)] = bytes
+	spSchemas.lock.Unlock()
+	sendPortMessage(schema.P, bytes)
+}
+
+func removeSchema(portname string) {
+	spSchemas.lock.Lock()
+	defer spSchemas.lock.Unlock()
+	delete(spSchemas.schemas, strings.ToLower(portname))
+}
+
+// sendSchemas sends the schemas of the open ports to a new client
+func sendSchemas(c *connection) {
+	spSchemas.lock.Lock()
+	defer spSchemas.lock.Unlock()
+	for port, bytes := range spSchemas.schemas {
+		if c.subscribed(topicTelemetry, port) {
+			c.send <- bytes
+		}
+	}
+}
+
+// telemetryFormat is the format asked for in the query, raw when it is
+// unknown
+func telemetryFormat(format string) string {
+	switch format {
+	case telemetryTyped, telemetryBoth:
+		return format
+	}
+	return telemetryRaw
+}
+
+// messages returns the messages for a client with the format
+func (m telemetryBroadcast) messages(format string) [][]byte {
+	if m.typed == nil {
+		return [][]byte{m.raw}
+	}
+	switch format {
+	case telemetryTyped:
+		return [][]byte{m.typed}
+	case telemetryBoth:
+		return [][]byte{m.raw, m.typed}
+	}
+	return [][]byte{m.raw}
+}
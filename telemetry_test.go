@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/3devo/dvconnector/models"
+)
+
+func TestDiffSchema(t *testing.T) {
+	columns := map[string]models.Column{"Temp1": {Name: "Temp1", Type: "number"}}
+	schema := func(header ...string) schemaReport {
+		return newSchema("COM3", header, columns)
+	}
+	tests := []struct {
+		name        string
+		previous    schemaReport
+		schema      schemaReport
+		wantAdded   []string
+		wantRemoved []string
+		wantChanged bool
+	}{
+		{"same header", schema("Time", "Temp1"), schema("Time", "Temp1"), nil, nil, false},
+		{"first header", schemaReport{}, schema("Time", "Temp1"), []string{"Time", "Temp1"}, nil, true},
+		{"added column", schema("Time", "Temp1"), schema("Time", "Temp1", "Status"), []string{"Status"}, nil, true},
+		{"removed column", schema("Time", "Temp1", "Status"), schema("Time", "Status"), nil, []string{"Temp1"}, true},
+		{"renamed column", schema("Time", "Temp1"), schema("Time", "Temp2"), []string{"Temp2"}, []string{"Temp1"}, true},
+		{"moved column", schema("Time", "Temp1", "Status"), schema("Time", "Status", "Temp1"), nil, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, removed, changed := diffSchema(test.previous, test.schema)
+			if !equalStrings(added, test.wantAdded) || !equalStrings(removed, test.wantRemoved) || changed != test.wantChanged {
+				t.Errorf("diffSchema = %v, %v, %v, want %v, %v, %v", added, removed, changed, test.wantAdded, test.wantRemoved, test.wantChanged)
+			}
+		})
+	}
+}
+
+func TestSchemaTelemetry(t *testing.T) {
+	columns := map[string]models.Column{"Time": {Name: "Time", Type: "number"}, "Temp1": {Name: "Temp1", Type: "number"}}
+	schema := newSchema("COM3", []string{"Time", "Temp1", "Status"}, columns)
+	tests := []struct {
+		name string
+		line []string
+		want map[string]interface{}
+	}{
+		{"typed values", []string{"12", "200.5", "Idle"}, map[string]interface{}{"Time": 12.0, "Temp1": 200.5, "Status": "Idle"}},
+		{"salvaged line", []string{"12", "", "Idle"}, map[string]interface{}{"Time": 12.0, "Temp1": nil, "Status": "Idle"}},
+		{"short line", []string{"12"}, map[string]interface{}{"Time": 12.0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := schema.telemetry(test.line, nil).Values
+			if len(values) != len(test.want) {
+				t.Fatalf("values %v, want %v", values, test.want)
+			}
+			for name, want := range test.want {
+				if got, found := values[name]; !found || got != want {
+					t.Errorf("%v is %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
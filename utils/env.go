@@ -10,4 +10,13 @@ type Env struct {
 	ConfigDir string
 	Db        *storm.DB
 	Validator *validator.Validate
+	// Broadcast sends a message to every websocket client, it is nil when
+	// there is no hub
+	Broadcast func(message []byte)
+	// Ports returns the serial ports like the list command does, it is nil
+	// when there is no serial hub
+	Ports func() interface{}
+	// PortSnapshot returns the live state of a port, false when the port is
+	// unknown
+	PortSnapshot func(name string) (interface{}, bool)
 }
@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	validator "gopkg.in/go-playground/validator.v9"
 )
 
 // IsValidUUID is a function that returns true if the input is a valid uuid.
@@ -24,3 +29,22 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// RequiredIf is the required_if validation of later validator versions, the
+// field is required when the other fields of its struct hold the values,
+// i.e. required_if=Type waitFor
+func RequiredIf(fl validator.FieldLevel) bool {
+	params := strings.Fields(fl.Param())
+	if len(params)%2 != 0 {
+		panic("Bad param number for required_if on " + fl.FieldName())
+	}
+	parent := reflect.Indirect(fl.Parent())
+	for i := 0; i < len(params); i += 2 {
+		other := parent.FieldByName(params[i])
+		if !other.IsValid() || fmt.Sprint(other.Interface()) != params[i+1] {
+			return true
+		}
+	}
+	field := fl.Field()
+	return field.IsValid() && !reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface())
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-// swagger:parameters GetAllLogFiles GetAllCharts GetAllSheets GetAllWorkspaces
+// swagger:parameters GetAllLogFiles GetAllCharts GetAllSheets GetAllWorkspaces GetAllSequences GetAllColumns GetAllFaultCodes
 type QueryBuilderParams struct {
 	//[{"key": "ID", "value": 1}] Array of values you want to filter
 	Filter string `json:"filter"`
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+// The watchdog notices when a Filament Maker freezes while the usb link
+// stays up. The stream makes progress when a valid data line comes in with a
+// Time column that moved on. Without progress for StallTimeout the stream
+// counts as stalled until the next progress. Time going backwards means the
+// device rebooted.
+
+type watchdogReport struct {
+	Cmd  string
+	Desc string
+	Port string
+	// device Time of the last progress
+	Time float64
+	// device Time before the reboot
+	PrevTime float64 `json:",omitempty"`
+	// seconds without progress
+	Seconds float64 `json:",omitempty"`
+}
+
+type streamWatchdog struct {
+	lastProgress time.Time
+	deviceTime   float64
+	hasData      bool
+	stalled      bool
+	stop         chan bool
+}
+
+// startWatchdog checks the stream for stalls until the buffer flow closes
+func (b *Bufferflow3Devo) startWatchdog() {
+	b.watchdog.stop = make(chan bool)
+	if b.StallTimeout <= 0 {
+		return
+	}
+	interval := b.StallTimeout / 4
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.watchdog.stop:
+				return
+			case now := <-ticker.C:
+				b.checkStalled(now)
+			}
+		}
+	}()
+}
+
+func (b *Bufferflow3Devo) stopWatchdog() {
+	close(b.watchdog.stop)
+}
+
+func (b *Bufferflow3Devo) checkStalled(now time.Time) {
+	b.valuesLock.Lock()
+	w := &b.watchdog
+	if !w.hasData || w.stalled || now.Sub(w.lastProgress) < b.StallTimeout {
+		b.valuesLock.Unlock()
+		return
+	}
+	w.stalled = true
+	report := watchdogReport{
+		Cmd:     "StreamStalled",
+		Desc:    "No new data came in from the device.",
+		Port:    b.Port,
+		Time:    w.deviceTime,
+		Seconds: now.Sub(w.lastProgress).Seconds(),
+	}
+	b.valuesLock.Unlock()
+
+	log.Printf("Stream of %v stalled at device time %v\n", b.Port, report.Time)
+	sendWatchdogReport(report)
+}
+
+// watchData feeds a valid data line to the watchdog. It must be called with
+// valuesLock held.
+func (b *Bufferflow3Devo) watchData(line []string) {
+	w := &b.watchdog
+	// without a Time column every line is progress
+	deviceTime, hasTime := 0.0, false
+	for i, name := range b.header {
+		if name != "Time" || i >= len(line) {
+			continue
+		}
+		// a salvaged line can miss its time
+		if value, err := strconv.ParseFloat(line[i], 64); err == nil {
+			deviceTime, hasTime = value, true
+		}
+	}
+
+	now := time.Now()
+	switch {
+	case !w.hasData:
+		w.hasData = true
+	case !hasTime:
+	case deviceTime < w.deviceTime:
+		log.Printf("Device on %v rebooted, time went from %v to %v\n", b.Port, w.deviceTime, deviceTime)
+		sendWatchdogReport(watchdogReport{
+			Cmd:      "DeviceRebooted",
+			Desc:     "The device time went backwards, it rebooted.",
+			Port:     b.Port,
+			Time:     deviceTime,
+			PrevTime: w.deviceTime,
+		})
+	case deviceTime == w.deviceTime:
+		// the device repeats itself, that is no progress
+		return
+	}
+
+	if w.stalled {
+		w.stalled = false
+		log.Printf("Stream of %v resumed\n", b.Port)
+		sendWatchdogReport(watchdogReport{
+			Cmd:     "StreamResumed",
+			Desc:    "New data came in from the device again.",
+			Port:    b.Port,
+			Time:    deviceTime,
+			Seconds: now.Sub(w.lastProgress).Seconds(),
+		})
+	}
+	w.deviceTime = deviceTime
+	w.lastProgress = now
+}
+
+func sendWatchdogReport(report watchdogReport) {
+	bytes, err := json.Marshal(report)
+	if err == nil {
+		h.broadcastSys <- bytes
+	}
+}